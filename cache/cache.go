@@ -2,7 +2,10 @@ package cache
 
 import (
 	"fmt"
+	"hash/crc32"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lomik/go-carbon/helper"
@@ -11,124 +14,513 @@ import (
 	"github.com/Sirupsen/logrus"
 )
 
+// WriteStrategy defines order of metrics sent to persister
+type WriteStrategy int
+
+const (
+	// MaximumLength writes metrics with most points first
+	MaximumLength WriteStrategy = iota
+	// Sorted writes metrics in name order
+	Sorted
+	// Noop writes metrics in random (map iteration) order without sorting
+	Noop
+)
+
+// ParseWriteStrategy returns WriteStrategy by config value: "max", "sorted" or "noop"
+func ParseWriteStrategy(value string) (WriteStrategy, error) {
+	switch value {
+	case "max":
+		return MaximumLength, nil
+	case "sorted":
+		return Sorted, nil
+	case "noop":
+		return Noop, nil
+	}
+	return MaximumLength, fmt.Errorf("unknown cache write strategy %#v", value)
+}
+
 type queue []*queueItem
 
 func (v queue) Len() int           { return len(v) }
 func (v queue) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
 func (v queue) Less(i, j int) bool { return v[i].count < v[j].count }
 
-// Cache stores and aggregate metrics in memory
+// byName sorts queue in reverse name order. Queue is popped from the end
+type byName struct{ queue }
+
+func (v byName) Less(i, j int) bool { return v.queue[i].metric > v.queue[j].metric }
+
+// Approximate memory usage of cache records
+const (
+	pointMemory  = 16 // points.Point struct
+	slotMemory   = 8  // pointer to points.Point in slice
+	recordMemory = 96 // points.Points struct, map key, map value and queue item
+)
+
+// memorySize returns approximate memory usage of cache record in bytes
+func memorySize(p *points.Points) int64 {
+	return int64(recordMemory + len(p.Metric) + cap(p.Data)*slotMemory + len(p.Data)*pointMemory)
+}
+
+type shard struct {
+	sync.Mutex
+	data     map[string]*points.Points
+	queue    queue
+	inFlight *points.Points // popped but not passed to confirm tracker yet
+
+	mergeRules map[string]mergeRule  // merge rules of cached metrics
+	mergeCount map[*points.Point]int // count of points merged into cached point by average
+
+	notBefore map[string]time.Time // requeued metrics are not popped before this time
+	retryAt   time.Time            // earliest time in notBefore
+}
+
+func newShard() *shard {
+	return &shard{
+		data:  make(map[string]*points.Points, 0),
+		queue: make(queue, 0),
+
+		mergeRules: make(map[string]mergeRule),
+		mergeCount: make(map[*points.Point]int),
+		notBefore:  make(map[string]time.Time),
+	}
+}
+
+// ready returns false if requeued metric should not be popped yet
+func (s *shard) ready(metric string, now time.Time) bool {
+	if len(s.notBefore) == 0 {
+		return true
+	}
+	t, exists := s.notBefore[metric]
+	if !exists {
+		return true
+	}
+	if now.Before(t) {
+		return false
+	}
+	delete(s.notBefore, metric)
+	return true
+}
+
+// forget removes merge and requeue state of metric deleted from shard. Merge counts of popped
+// points are kept until persister confirms them, because points may be requeued
+func (s *shard) forget(values *points.Points) {
+	delete(s.notBefore, values.Metric)
+	delete(s.mergeRules, values.Metric)
+}
+
+// release removes merge counts of points
+func (s *shard) release(values *points.Points) {
+	if len(s.mergeCount) > 0 {
+		for _, p := range values.Data {
+			delete(s.mergeCount, p)
+		}
+	}
+}
+
+// peek returns queue head without popping. Queue is updated if empty
+func (s *shard) peek(strategy WriteStrategy, now time.Time) *queueItem {
+	// all metrics of shard are requeued
+	if len(s.notBefore) == len(s.data) && now.Before(s.retryAt) {
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		for size := len(s.queue); size > 0; size = len(s.queue) {
+			item := s.queue[size-1]
+			if _, ok := s.data[item.metric]; ok && s.ready(item.metric, now) {
+				return item
+			}
+			s.queue = s.queue[:size-1]
+		}
+		if i == 0 {
+			s.updateQueue(strategy)
+		}
+	}
+	return nil
+}
+
+func (s *shard) getNext(now time.Time) *points.Points {
+	for {
+		size := len(s.queue)
+		if size == 0 {
+			break
+		}
+		cacheRecord := s.queue[size-1]
+		s.queue = s.queue[:size-1]
+
+		if values, ok := s.data[cacheRecord.metric]; ok && s.ready(cacheRecord.metric, now) {
+			return values
+		}
+	}
+	return nil
+}
+
+func (s *shard) getAny(now time.Time) *points.Points {
+	for metric, values := range s.data {
+		if s.ready(metric, now) {
+			return values
+		}
+	}
+	return nil
+}
+
+func (s *shard) get(strategy WriteStrategy) *points.Points {
+	now := time.Now()
+
+	// all metrics of shard are requeued
+	if len(s.notBefore) == len(s.data) && now.Before(s.retryAt) {
+		return nil
+	}
+
+	if values := s.getNext(now); values != nil {
+		return values
+	}
+
+	s.updateQueue(strategy)
+
+	if values := s.getNext(now); values != nil {
+		return values
+	}
+
+	return s.getAny(now)
+}
+
+func (s *shard) updateQueue(strategy WriteStrategy) {
+	if strategy == Noop {
+		s.queue = s.queue[:0]
+		return
+	}
+
+	newQueue := make(queue, 0, len(s.data))
+
+	now := time.Now()
+	s.retryAt = time.Time{}
+	for key, values := range s.data {
+		if !s.ready(key, now) {
+			if t := s.notBefore[key]; s.retryAt.IsZero() || t.Before(s.retryAt) {
+				s.retryAt = t
+			}
+			continue
+		}
+		newQueue = append(newQueue, &queueItem{key, len(values.Data)})
+	}
+
+	if strategy == Sorted {
+		sort.Sort(byName{newQueue})
+	} else {
+		sort.Sort(newQueue)
+	}
+
+	s.queue = newQueue
+}
+
+// Cache stores and aggregate metrics in memory. Metrics are split into shards by name hash,
+// each shard has own lock
 type Cache struct {
 	helper.Stoppable
-	data           map[string]*points.Points
-	size           int
-	maxSize        int
-	inputChan      chan *points.Points // from receivers
-	inputCapacity  int                 // buffer size of inputChan
-	outputChan     chan *points.Points // to persisters
-	queryChan      chan *Query         // from carbonlink
-	confirmChan    chan *points.Points // for persisted confirmation
-	metricInterval time.Duration       // checkpoint interval
-	graphPrefix    string
-	queryCnt       int
-	overflowCnt    int // drop packages if cache full
-	queue          queue
+	shards            []*shard
+	nextShard         int   // round robin position of Pop
+	size              int64 // total points in all shards. Atomic
+	memory            int64 // approximate memory usage of all shards in bytes. Atomic
+	maxSize           int
+	maxMemory         int64
+	writeStrategy     WriteStrategy
+	overflowPolicy    OverflowPolicy
+	overflowMaxPoints int
+	rejected          rejectedList        // dropped points by metric
+	inputChan         chan *points.Points // from receivers
+	inputCapacity     int                 // buffer size of inputChan
+	outputChan        chan *points.Points // to persisters
+	queryChan         chan *Query         // from carbonlink
+	confirmChan       chan *points.Points // for persisted confirmation
+	notifyChan        chan bool           // wake up output worker on new points
+	freeChan          chan bool           // wake up blocked input workers after pop
+	metricInterval    time.Duration       // checkpoint interval
+	graphPrefix       string
+	queryCnt          uint32   // atomic
+	overflowCnt       uint32   // drop packages if cache full. Atomic
+	blockedCnt        uint32   // input blocked by full cache. Atomic
+	journal           *Journal // optional write-ahead log
+	confirmTracker    *notConfirmed
+	mergeStrategy     MergeStrategy
+	mergeFunc         atomic.Value // MergeFunc
+	mergedCnt         uint32       // points merged with cached points of the same step. Atomic
 }
 
 // New create Cache instance and run in/out goroutine
 func New() *Cache {
 	cache := &Cache{
-		data:           make(map[string]*points.Points, 0),
-		size:           0,
-		maxSize:        1000000,
-		metricInterval: time.Minute,
-		queryChan:      make(chan *Query, 16),
-		graphPrefix:    "carbon.",
-		queryCnt:       0,
-		queue:          make(queue, 0),
-		confirmChan:    make(chan *points.Points, 2048),
-		inputCapacity:  51200,
+		maxSize:           1000000,
+		metricInterval:    time.Minute,
+		queryChan:         make(chan *Query, 16),
+		graphPrefix:       "carbon.",
+		confirmChan:       make(chan *points.Points, 2048),
+		notifyChan:        make(chan bool, 1),
+		freeChan:          make(chan bool, 1),
+		overflowMaxPoints: 1000,
+		inputCapacity:     51200,
 		// inputChan:   make(chan *points.Points, 51200), create in In() getter
 	}
+	cache.SetShards(16)
 	return cache
 }
 
+// SetShards sets count of cache shards. Call before Start() and any Add()
+func (c *Cache) SetShards(count int) {
+	if count < 1 {
+		count = 1
+	}
+	c.shards = make([]*shard, count)
+	for i := 0; i < count; i++ {
+		c.shards[i] = newShard()
+	}
+	c.nextShard = 0
+	atomic.StoreInt64(&c.size, 0)
+	atomic.StoreInt64(&c.memory, 0)
+}
+
 // SetInputCapacity set buffer size of input channel. Call before In() getter
 func (c *Cache) SetInputCapacity(size int) {
 	c.inputCapacity = size
 }
 
+// SetJournal enables write-ahead log. Call before Start()
+func (c *Cache) SetJournal(journal *Journal) {
+	c.journal = journal
+}
+
+// SetWriteStrategy sets order of metrics sent to persister. Call before Start()
+func (c *Cache) SetWriteStrategy(strategy WriteStrategy) {
+	c.writeStrategy = strategy
+}
+
 // SetMetricInterval sets doChekpoint interval
 func (c *Cache) SetMetricInterval(interval time.Duration) {
 	c.metricInterval = interval
 }
 
-func (c *Cache) getNext() *points.Points {
-	for {
-		size := len(c.queue)
-		if size == 0 {
-			break
-		}
-		cacheRecord := c.queue[size-1]
-		c.queue = c.queue[:size-1]
+func (c *Cache) shardIndex(metric string) int {
+	return int(crc32.ChecksumIEEE([]byte(metric)) % uint32(len(c.shards)))
+}
 
-		if values, ok := c.data[cacheRecord.metric]; ok {
+func (c *Cache) shard(metric string) *shard {
+	return c.shards[c.shardIndex(metric)]
+}
+
+// Get any key/values pair from Cache
+func (c *Cache) Get() *points.Points {
+	for i := 0; i < len(c.shards); i++ {
+		s := c.shards[(c.nextShard+i)%len(c.shards)]
+		s.Lock()
+		values := s.get(c.writeStrategy)
+		s.Unlock()
+		if values != nil {
 			return values
 		}
 	}
 	return nil
 }
 
-func (c *Cache) getAny() *points.Points {
-	for _, values := range c.data {
-		return values
+// Remove key from cache
+func (c *Cache) Remove(key string) {
+	s := c.shard(key)
+	s.Lock()
+	if value, exists := s.data[key]; exists {
+		atomic.AddInt64(&c.size, -int64(len(value.Data)))
+		atomic.AddInt64(&c.memory, -memorySize(value))
+		delete(s.data, key)
+		s.forget(value)
+		s.release(value)
 	}
-	return nil
+	s.Unlock()
 }
 
-// Get any key/values pair from Cache
-func (c *Cache) Get() *points.Points {
-	if values := c.getNext(); values != nil {
-		return values
+// Pop return and remove next for save point from cache. With "max" and "sorted" write strategies
+// the next metric is chosen from heads of all shard queues, otherwise shards are visited in round robin order
+func (c *Cache) Pop() *points.Points {
+	if c.Size() == 0 {
+		return nil
 	}
 
-	c.updateQueue()
-
-	if values := c.getNext(); values != nil {
-		return values
+	if c.writeStrategy == MaximumLength || c.writeStrategy == Sorted {
+		if s := c.nextOrdered(); s != nil {
+			if values := c.popShard(s); values != nil {
+				return values
+			}
+		}
 	}
 
-	return c.getAny()
+	for i := 0; i < len(c.shards); i++ {
+		s := c.shards[c.nextShard]
+		c.nextShard = (c.nextShard + 1) % len(c.shards)
+
+		if values := c.popShard(s); values != nil {
+			return values
+		}
+	}
+	return nil
 }
 
-// Remove key from cache
-func (c *Cache) Remove(key string) {
-	if value, exists := c.data[key]; exists {
-		c.size -= len(value.Data)
-		delete(c.data, key)
+// nextOrdered returns shard with metric of most points or lowest name at queue head
+func (c *Cache) nextOrdered() *shard {
+	now := time.Now()
+
+	var best *queueItem
+	var bestShard *shard
+	for _, s := range c.shards {
+		s.Lock()
+		item := s.peek(c.writeStrategy, now)
+		s.Unlock()
+
+		if item == nil {
+			continue
+		}
+		if best == nil ||
+			(c.writeStrategy == Sorted && item.metric < best.metric) ||
+			(c.writeStrategy == MaximumLength && item.count > best.count) {
+			best = item
+			bestShard = s
+		}
 	}
+	return bestShard
 }
 
-// Pop return and remove next for save point from cache
-func (c *Cache) Pop() *points.Points {
-	if c.size == 0 {
-		return nil
+// popShard removes next metric of shard
+func (c *Cache) popShard(s *shard) *points.Points {
+	s.Lock()
+	values := s.get(c.writeStrategy)
+	if values != nil {
+		delete(s.data, values.Metric)
+		s.forget(values)
+		atomic.AddInt64(&c.size, -int64(len(values.Data)))
+		atomic.AddInt64(&c.memory, -memorySize(values))
+		s.inFlight = values
 	}
-	v := c.Get()
-	if v != nil {
-		c.Remove(v.Metric)
+	s.Unlock()
+
+	if values != nil {
+		select {
+		case c.freeChan <- true:
+		default:
+		}
+	}
+	return values
+}
+
+// sent clears popped points passed to confirm tracker
+func (c *Cache) sent(values *points.Points) {
+	s := c.shard(values.Metric)
+	s.Lock()
+	if s.inFlight == values {
+		s.inFlight = nil
 	}
-	return v
+	s.Unlock()
+}
+
+// release removes merge state of popped points confirmed by persister
+func (c *Cache) release(values *points.Points) {
+	s := c.shard(values.Metric)
+	s.Lock()
+	s.release(values)
+	s.Unlock()
 }
 
 // Add points to cache
 func (c *Cache) Add(p *points.Points) {
-	if values, exists := c.data[p.Metric]; exists {
-		values.Data = append(values.Data, p.Data...)
+	var segment uint64
+	if c.journal != nil {
+		segment = c.journal.current()
+	}
+	c.add(p, segment)
+}
+
+// Requeue returns points of popped metric to cache. Metric is not popped again before notBefore.
+// Requeued points are older than cached ones and are put in front of them.
+// Points are not written to journal again and are never dropped by overflow policy
+func (c *Cache) Requeue(values *points.Points, notBefore time.Time) {
+	var segment uint64
+	if c.journal != nil {
+		segment = c.journal.segmentOf(values)
+	}
+	c.put(values.Copy(), segment, notBefore)
+}
+
+// add points to cache. Segment is journal segment with points
+func (c *Cache) add(p *points.Points, segment uint64) {
+	c.put(p, segment, time.Time{})
+}
+
+// put adds points to cache. Not zero notBefore defers pop of metric
+func (c *Cache) put(p *points.Points, segment uint64, notBefore time.Time) {
+	var memory int64
+	var size, merged int
+
+	s := c.shard(p.Metric)
+	s.Lock()
+	if values, exists := s.data[p.Metric]; exists {
+		memory = -memorySize(values)
+		if !notBefore.IsZero() {
+			merged = s.requeue(values, p.Data, s.mergeRules[p.Metric])
+		} else if rule, ok := s.mergeRules[p.Metric]; ok {
+			for _, point := range p.Data {
+				if s.merge(values, point, rule) {
+					merged++
+				}
+			}
+		} else {
+			values.Data = append(values.Data, p.Data...)
+		}
+		memory += memorySize(values)
+		size = len(p.Data) - merged
+		if !notBefore.IsZero() && c.journal != nil {
+			c.journal.hold(values, segment) // requeued points may be in older segment
+		}
 	} else {
-		c.data[p.Metric] = p
+		rule := c.mergeRule(p.Metric)
+		if rule.step > 0 {
+			s.mergeRules[p.Metric] = rule
+		}
+		if !notBefore.IsZero() {
+			data := p.Data
+			p.Data = make([]*points.Point, 0, len(data))
+			merged = s.requeue(p, data, rule)
+		} else if rule.step > 0 && len(p.Data) > 1 {
+			data := p.Data
+			p.Data = make([]*points.Point, 0, len(data))
+			for _, point := range data {
+				if s.merge(p, point, rule) {
+					merged++
+				}
+			}
+		}
+		s.data[p.Metric] = p
+		memory = memorySize(p)
+		if c.journal != nil {
+			c.journal.hold(p, segment)
+		}
+		size = len(p.Data)
+	}
+	if !notBefore.IsZero() {
+		if t, exists := s.notBefore[p.Metric]; !exists || notBefore.After(t) {
+			s.notBefore[p.Metric] = notBefore
+		}
+		if s.retryAt.IsZero() || notBefore.Before(s.retryAt) {
+			s.retryAt = notBefore
+		}
+	}
+	s.Unlock()
+
+	if merged > 0 {
+		atomic.AddUint32(&c.mergedCnt, uint32(merged))
+	}
+	atomic.AddInt64(&c.memory, memory)
+	atomic.AddInt64(&c.size, int64(size))
+
+	select {
+	case c.notifyChan <- true:
+	default:
 	}
-	c.size += len(p.Data)
 }
 
 // SetGraphPrefix for internal cache metrics
@@ -141,9 +533,35 @@ func (c *Cache) SetMaxSize(maxSize int) {
 	c.maxSize = maxSize
 }
 
+// SetMaxMemory sets limit of cache memory usage in bytes. 0 - unlimited
+func (c *Cache) SetMaxMemory(maxMemory int64) {
+	c.maxMemory = maxMemory
+}
+
 // Size returns size
 func (c *Cache) Size() int {
-	return c.size
+	return int(atomic.LoadInt64(&c.size))
+}
+
+// MemoryBytes returns approximate memory usage of cached points in bytes
+func (c *Cache) MemoryBytes() int64 {
+	return atomic.LoadInt64(&c.memory)
+}
+
+// isFull checks size and memory limits
+func (c *Cache) isFull() bool {
+	return c.overLimit(1)
+}
+
+// overLimit returns true if cache size or memory reached limit multiplied by factor
+func (c *Cache) overLimit(factor int) bool {
+	if c.maxSize != 0 && c.Size() >= c.maxSize*factor {
+		return true
+	}
+	if c.maxMemory != 0 && c.MemoryBytes() >= c.maxMemory*int64(factor) {
+		return true
+	}
+	return false
 }
 
 type queueItem struct {
@@ -155,19 +573,11 @@ type queueItem struct {
 func (c *Cache) stat(metric string, value float64) {
 	key := fmt.Sprintf("%scache.%s", c.graphPrefix, metric)
 	c.Add(points.OnePoint(key, value, time.Now().Unix()))
-	c.queue = append(c.queue, &queueItem{key, 1})
-}
 
-func (c *Cache) updateQueue() {
-	newQueue := make(queue, 0)
-
-	for key, values := range c.data {
-		newQueue = append(newQueue, &queueItem{key, len(values.Data)})
-	}
-
-	sort.Sort(newQueue)
-
-	c.queue = newQueue
+	s := c.shard(key)
+	s.Lock()
+	s.queue = append(s.queue, &queueItem{key, 1})
+	s.Unlock()
 }
 
 // doCheckpoint reorder save queue, add carbon metrics to queue
@@ -176,40 +586,136 @@ func (c *Cache) doCheckpoint() {
 
 	inputLenBeforeCheckpoint := len(c.inputChan)
 
-	c.updateQueue()
+	metrics := 0
+	for _, s := range c.shards {
+		s.Lock()
+		s.updateQueue(c.writeStrategy)
+		metrics += len(s.data)
+		s.Unlock()
+	}
 
 	inputLenAfterCheckpoint := len(c.inputChan)
 
 	worktime := time.Now().Sub(start)
 
-	c.stat("size", float64(c.size))
-	c.stat("metrics", float64(len(c.data)))
-	c.stat("queries", float64(c.queryCnt))
-	c.stat("overflow", float64(c.overflowCnt))
+	size := c.Size()
+	memory := c.MemoryBytes()
+	queryCnt := atomic.SwapUint32(&c.queryCnt, 0)
+	overflowCnt := atomic.SwapUint32(&c.overflowCnt, 0)
+	blockedCnt := atomic.SwapUint32(&c.blockedCnt, 0)
+	mergedCnt := atomic.SwapUint32(&c.mergedCnt, 0)
+
+	c.stat("size", float64(size))
+	c.stat("memoryBytes", float64(memory))
+	c.stat("metrics", float64(metrics))
+	c.stat("queries", float64(queryCnt))
+	c.stat("overflow", float64(overflowCnt))
+	c.stat("blocked", float64(blockedCnt))
+	c.stat("merged", float64(mergedCnt))
 	c.stat("checkpointTime", worktime.Seconds())
 	c.stat("inputLenBeforeCheckpoint", float64(inputLenBeforeCheckpoint))
 	c.stat("inputLenAfterCheckpoint", float64(inputLenAfterCheckpoint))
 
+	if c.journal != nil {
+		journalWritten, journalRemoved, journalHeld := c.journal.stat()
+		c.stat("journalWritten", float64(journalWritten))
+		c.stat("journalSegmentsRemoved", float64(journalRemoved))
+		c.stat("journalHeld", float64(journalHeld))
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"time":                     worktime.String(),
-		"size":                     c.size,
-		"metrics":                  len(c.data),
-		"queries":                  c.queryCnt,
-		"overflow":                 c.overflowCnt,
+		"size":                     size,
+		"memoryBytes":              memory,
+		"metrics":                  metrics,
+		"shards":                   len(c.shards),
+		"queries":                  queryCnt,
+		"overflow":                 overflowCnt,
+		"blocked":                  blockedCnt,
 		"inputLenBeforeCheckpoint": inputLenBeforeCheckpoint,
 		"inputLenAfterCheckpoint":  inputLenAfterCheckpoint,
 		"inputCapacity":            cap(c.inputChan),
 	}).Info("[cache] doCheckpoint()")
+}
 
-	c.queryCnt = 0
-	c.overflowCnt = 0
+// query fills CacheData of carbonlink query
+func (c *Cache) query(query *Query) {
+	s := c.shard(query.Metric)
+	s.Lock()
+	if s.inFlight != nil && s.inFlight.Metric == query.Metric {
+		query.CacheData = s.inFlight
+	} else if v, ok := s.data[query.Metric]; ok {
+		// copy slice: merge replaces cached points in place
+		query.CacheData = v.Copy()
+		query.CacheData.Data = append([]*points.Point(nil), v.Data...)
+	}
+	s.Unlock()
 }
 
-func (c *Cache) worker(exitChan chan bool) {
-	var values *points.Points
-	var sendTo chan *points.Points
-	var forceReceive bool
+// dispatcher sends received points to input worker of metric shard.
+// Points of one metric are journaled and added in order of receiving
+func (c *Cache) dispatcher(out [](chan *points.Points), exit chan bool) {
+	defer func() {
+		for _, ch := range out {
+			close(ch)
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-c.inputChan:
+			out[c.shardIndex(msg.Metric)] <- msg
+		case <-exit:
+			return // rest of input is dumped
+		}
+	}
+}
+
+// inputWorker adds points of one shard. One worker per shard is started
+func (c *Cache) inputWorker(in chan *points.Points, exit chan bool) {
+	for msg := range in {
+		if c.accept(msg, exit) {
+			var segment uint64
+			if c.journal != nil {
+				segment = c.journal.Write(msg)
+			}
+			c.add(msg, segment)
+		}
+	}
+}
+
+// outputWorker pops points from shards and sends them to persister through confirm tracker
+func (c *Cache) outputWorker(toConfirmTracker chan *points.Points, exit chan bool) {
+	// wake up for requeued metrics
+	retry := time.NewTicker(time.Second)
+	defer retry.Stop()
 
+	for {
+		values := c.Pop()
+		if values == nil {
+			select {
+			case <-c.notifyChan:
+				continue
+			case <-retry.C:
+				continue
+			case <-exit:
+				return
+			}
+		}
+
+		select {
+		case toConfirmTracker <- values:
+			c.sent(values)
+		case <-exit:
+			// return popped but not sent points back for Dump
+			c.sent(values)
+			c.Add(values)
+			return
+		}
+	}
+}
+
+func (c *Cache) worker(exitChan chan bool) {
 	toConfirmTracker := make(chan *points.Points)
 
 	confirmTracker := &notConfirmed{
@@ -221,62 +727,48 @@ func (c *Cache) worker(exitChan chan bool) {
 		out:            c.outputChan,
 		confirmed:      c.confirmChan,
 		cacheIn:        c.inputChan,
+		journal:        c.journal,
+		release:        c.release,
 	}
 
+	c.confirmTracker = confirmTracker
+
 	c.Go(func(exit chan bool) {
 		confirmTracker.worker(exit)
 	})
 
-	forceReceiveThreshold := cap(c.inputChan) / 10
+	var channels [](chan *points.Points)
+	for i := 0; i < len(c.shards); i++ {
+		ch := make(chan *points.Points, 32)
+		channels = append(channels, ch)
+		c.Go(func(exit chan bool) {
+			c.inputWorker(ch, exit)
+		})
+	}
+
+	c.Go(func(exit chan bool) {
+		c.dispatcher(channels, exit)
+	})
+
+	c.Go(func(exit chan bool) {
+		c.outputWorker(toConfirmTracker, exit)
+	})
 
 	ticker := time.NewTicker(c.metricInterval)
 	defer ticker.Stop()
 
-MAIN_LOOP:
 	for {
-
-		if len(c.inputChan) > forceReceiveThreshold {
-			forceReceive = true
-		} else {
-			forceReceive = false
-		}
-
-		if values == nil && !forceReceive {
-			values = c.Pop()
-		}
-
-		if values != nil {
-			sendTo = toConfirmTracker
-		} else {
-			sendTo = nil
-		}
-
 		select {
 		case <-ticker.C: // checkpoint
 			c.doCheckpoint()
 		case query := <-c.queryChan: // carbonlink
-			c.queryCnt++
-
-			if values != nil && values.Metric == query.Metric {
-				query.CacheData = values
-			} else if v, ok := c.data[query.Metric]; ok {
-				query.CacheData = v.Copy()
-			}
-
+			atomic.AddUint32(&c.queryCnt, 1)
+			c.query(query)
 			confirmTracker.queryChan <- query
-		case sendTo <- values: // to persister
-			values = nil
-		case msg := <-c.inputChan: // from receiver
-			if c.maxSize == 0 || c.size < c.maxSize {
-				c.Add(msg)
-			} else {
-				c.overflowCnt++
-			}
 		case <-exitChan: // exit
-			break MAIN_LOOP
+			return
 		}
 	}
-
 }
 
 // In returns input channel
@@ -320,6 +812,32 @@ func (c *Cache) Start() error {
 			c.outputChan = make(chan *points.Points, 1024)
 		}
 
+		if c.journal != nil {
+			// points restored from dump before start are not journaled yet
+			restored := make([]*points.Points, 0)
+			for _, s := range c.shards {
+				for _, values := range s.data {
+					restored = append(restored, values)
+				}
+			}
+			c.SetShards(len(c.shards))
+
+			if err := c.journal.Open(c.Add); err != nil {
+				return err
+			}
+
+			for _, values := range restored {
+				c.add(values, c.journal.Write(values))
+			}
+			if err := c.journal.sync(); err != nil {
+				return err
+			}
+
+			c.Go(func(exit chan bool) {
+				c.journal.worker(exit)
+			})
+		}
+
 		c.Go(func(exit chan bool) {
 			c.worker(exit)
 		})
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCache(t *testing.T) {
@@ -97,3 +98,178 @@ func TestCacheCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestCacheShards(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetShards(4)
+
+	for i := 0; i < 100; i++ {
+		c.Add(points.OnePoint(fmt.Sprintf("metric%d", i), float64(i), 10))
+	}
+	c.Add(points.OnePoint("metric1", 42, 20))
+
+	assert.Equal(101, c.Size())
+
+	used := 0
+	for _, s := range c.shards {
+		if len(s.data) > 0 {
+			used++
+		}
+	}
+	assert.Equal(4, used)
+
+	popped := make(map[string]int)
+	for values := c.Pop(); values != nil; values = c.Pop() {
+		popped[values.Metric] += len(values.Data)
+	}
+
+	assert.Equal(100, len(popped))
+	assert.Equal(2, popped["metric1"])
+	assert.Equal(0, c.Size())
+}
+
+func TestCacheWriteStrategy(t *testing.T) {
+	assert := assert.New(t)
+
+	order := func(strategy WriteStrategy, shards int) []string {
+		c := New()
+		c.SetShards(shards)
+		c.SetWriteStrategy(strategy)
+
+		c.Add(points.OnePoint("b", 1, 10))
+		c.Add(points.OnePoint("c", 1, 10))
+		c.Add(points.OnePoint("c", 1, 20))
+		c.Add(points.OnePoint("a", 1, 10))
+		c.Add(points.OnePoint("a", 1, 20))
+		c.Add(points.OnePoint("a", 1, 30))
+		for i := int64(0); i < 4; i++ {
+			c.Add(points.OnePoint("f", 1, 10+i))
+		}
+
+		var result []string
+		for values := c.Pop(); values != nil; values = c.Pop() {
+			result = append(result, values.Metric)
+		}
+		return result
+	}
+
+	// order is kept across shards
+	for _, shards := range []int{1, 16} {
+		assert.Equal([]string{"f", "a", "c", "b"}, order(MaximumLength, shards))
+		assert.Equal([]string{"a", "b", "c", "f"}, order(Sorted, shards))
+		assert.Len(order(Noop, shards), 4)
+	}
+
+	s, err := ParseWriteStrategy("sorted")
+	assert.NoError(err)
+	assert.Equal(Sorted, s)
+
+	_, err = ParseWriteStrategy("unknown")
+	assert.Error(err)
+}
+
+func TestCacheMemoryBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMaxMemory(1)
+
+	assert.False(c.isFull())
+
+	c.Add(points.OnePoint("hello.world", 42, 10))
+	assert.Equal(int64(recordMemory+len("hello.world")+slotMemory+pointMemory), c.MemoryBytes())
+	assert.True(c.isFull())
+
+	c.Add(points.OnePoint("hello.world", 15, 12))
+	c.Add(points.OnePoint("metric.name", 1, 10))
+	assert.True(c.MemoryBytes() > int64(2*recordMemory))
+
+	c.Remove("metric.name")
+	c.Pop()
+
+	assert.Equal(int64(0), c.MemoryBytes())
+}
+
+func TestCacheRequeue(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMaxSize(1)
+
+	c.Add(points.OnePoint("hello.world", 42, 10))
+	values := c.Pop()
+
+	// requeue bypasses overflow policy of full cache
+	c.Add(points.OnePoint("other.metric", 1, 10))
+	c.Requeue(values, time.Now().Add(100*time.Millisecond))
+	c.Add(points.OnePoint("hello.world", 15, 20))
+	assert.Equal(3, c.Size())
+
+	p := c.Pop()
+	assert.Equal("other.metric", p.Metric)
+	assert.Nil(c.Pop())
+
+	time.Sleep(100 * time.Millisecond)
+	p = c.Pop()
+	if assert.NotNil(p) {
+		assert.Equal("hello.world", p.Metric)
+		assert.Len(p.Data, 2)
+	}
+	assert.Equal(0, c.Size())
+}
+
+func TestCacheRequeueWorker(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetOutputChanSize(0)
+	assert.NoError(c.Start())
+	defer c.Stop()
+
+	c.Requeue(points.OnePoint("hello.world", 42, 10), time.Now().Add(100*time.Millisecond))
+
+	// output worker retries without new points
+	select {
+	case p := <-c.Out():
+		assert.Equal("hello.world", p.Metric)
+		assert.Len(p.Data, 1)
+	case <-time.After(3 * time.Second):
+		t.Fatal("requeued points are not popped")
+	}
+}
+
+func TestCacheInputOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMergeStrategy(MergeLast)
+	c.SetMergeFunc(func(metric string) (int64, string) {
+		return 60, "average"
+	})
+	c.SetMetricInterval(time.Hour)
+	c.Start()
+	defer c.Stop()
+
+	for i := 0; i < 1000; i++ {
+		c.In() <- points.OnePoint(fmt.Sprintf("metric%d", i%3), float64(i), 1422698155)
+	}
+
+	// last received point wins
+	last := make(map[string]float64)
+	for {
+		select {
+		case p := <-c.Out():
+			assert.Len(p.Data, 1)
+			if v, exists := last[p.Metric]; exists {
+				assert.True(p.Data[0].Value > v, "points of %s are reordered", p.Metric)
+			}
+			last[p.Metric] = p.Data[0].Value
+			continue
+		case <-time.After(200 * time.Millisecond):
+		}
+		break
+	}
+	assert.Equal(map[string]float64{"metric0": 999, "metric1": 997, "metric2": 998}, last)
+}
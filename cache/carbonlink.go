@@ -65,6 +65,7 @@ type CarbonlinkListener struct {
 	readTimeout  time.Duration
 	queryTimeout time.Duration
 	tcpListener  *net.TCPListener
+	tlsConfig    *helper.TLSConfig // nil - plain TCP
 }
 
 // NewCarbonlinkListener create new instance of CarbonlinkListener
@@ -86,6 +87,16 @@ func (listener *CarbonlinkListener) SetQueryTimeout(timeout time.Duration) {
 	listener.queryTimeout = timeout
 }
 
+// SetTLS enables TLS. Call before Listen()
+func (listener *CarbonlinkListener) SetTLS(config *helper.TLSConfig) {
+	listener.tlsConfig = config
+}
+
+// ReloadTLS reads certificates from files of current config. Active connections are not affected
+func (listener *CarbonlinkListener) ReloadTLS(certFile, keyFile, caFile string) error {
+	return helper.ReloadTLS(listener.tlsConfig, certFile, keyFile, caFile)
+}
+
 func (listener *CarbonlinkListener) packReply(query *Query) []byte {
 	buf := new(bytes.Buffer)
 
@@ -214,6 +225,10 @@ func (listener *CarbonlinkListener) Listen(addr *net.TCPAddr) error {
 					continue
 				}
 
+				if listener.tlsConfig != nil {
+					conn = listener.tlsConfig.Server(conn)
+				}
+
 				go listener.handleConnection(conn)
 			}
 		})
@@ -17,6 +17,8 @@ type notConfirmed struct {
 	out            chan *points.Points
 	confirmed      chan *points.Points
 	cacheIn        chan *points.Points
+	journal        *Journal
+	release        func(p *points.Points) // clears cache merge state of confirmed points
 	size           int
 }
 
@@ -30,6 +32,13 @@ func (m *notConfirmed) add(p *points.Points) {
 }
 
 func (m *notConfirmed) confirm(p *points.Points) {
+	if m.journal != nil {
+		m.journal.release(p)
+	}
+	if m.release != nil {
+		m.release(p)
+	}
+
 	values, exists := m.data[p.Metric]
 	if !exists {
 		return
@@ -71,7 +80,10 @@ func (m *notConfirmed) handleQuery(query *Query) {
 func (m *notConfirmed) stat(metric string, value float64) {
 	key := fmt.Sprintf("%scache.%s", m.graphPrefix, metric)
 	p := points.NowPoint(key, value)
-	m.cacheIn <- p
+	select {
+	case m.cacheIn <- p:
+	default: // input is full. Don't block sending to persister
+	}
 }
 
 func (m *notConfirmed) doCheckpoint() {
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// Dump writes all points from cache, from input channel and not confirmed by persister
+// in text protocol. Call only after Stop(). Journal is kept until PurgeJournal() is called
+func (c *Cache) Dump(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+
+	var written int
+
+	dump := func(values *points.Points) error {
+		if _, err := values.WriteTo(writer); err != nil {
+			return err
+		}
+		written += len(values.Data)
+		return nil
+	}
+
+	if c.confirmTracker != nil {
+		for _, list := range c.confirmTracker.data {
+			for _, values := range list {
+				if err := dump(values); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, s := range c.shards {
+		for _, values := range s.data {
+			if err := dump(values); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.inputChan != nil {
+	INPUT_LOOP:
+		for {
+			select {
+			case values := <-c.inputChan:
+				if err := dump(values); err != nil {
+					return err
+				}
+			default:
+				break INPUT_LOOP
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"points": written,
+	}).Info("[cache] dumped")
+
+	return nil
+}
+
+// PurgeJournal removes journal segments. Call after dump with all points from journal is saved
+func (c *Cache) PurgeJournal() {
+	if c.journal != nil {
+		c.journal.Purge()
+	}
+}
+
+// Restore loads points written by Dump. Call before Start()
+func (c *Cache) Restore(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	var restored, skipped int
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		p, err := points.ParseText(line)
+		if err != nil {
+			skipped++
+			continue
+		}
+
+		c.Add(p)
+		restored++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"points":  restored,
+		"skipped": skipped,
+	}).Info("[cache] restored")
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetOutputChanSize(0)
+	assert.NoError(c.Start())
+
+	c.In() <- points.OnePoint("hello.world", 42, 10)
+	c.In() <- points.OnePoint("metric.name", -1.5, 10)
+
+	time.Sleep(50 * time.Millisecond)
+
+	// in-flight point: received by persister but not confirmed
+	inFlight := <-c.Out()
+
+	c.Stop()
+
+	buf := new(bytes.Buffer)
+	assert.NoError(c.Dump(buf))
+
+	restored := New()
+	assert.NoError(restored.Restore(buf))
+
+	assert.Equal(2, restored.Size())
+	if assert.NotNil(restored.shard(inFlight.Metric).data[inFlight.Metric]) {
+		assert.True(restored.shard(inFlight.Metric).data[inFlight.Metric].Eq(inFlight))
+	}
+	assert.NotNil(restored.shard("hello.world").data["hello.world"])
+	assert.NotNil(restored.shard("metric.name").data["metric.name"])
+}
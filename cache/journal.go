@@ -0,0 +1,403 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+const journalSuffix = ".journal"
+
+// Journal is write-ahead log of points received by cache. Points are stored in
+// plain text protocol to segment files. Segment removed after all points from it are
+// confirmed by persister
+type Journal struct {
+	sync.Mutex
+	dir          string
+	syncInterval time.Duration
+	segmentSize  int64
+
+	segment uint64 // current segment id
+	fd      *os.File
+	writer  *bufio.Writer
+	written int64 // bytes in current segment
+
+	held map[*points.Points]uint64 // cache records -> segment of first point
+	refs map[uint64]int            // segment -> count of held cache records
+
+	// counters for stat
+	writtenPoints uint32
+	removed       uint32
+}
+
+// NewJournal create new instance of Journal
+func NewJournal(dir string) *Journal {
+	return &Journal{
+		dir:          dir,
+		syncInterval: time.Second,
+		segmentSize:  67108864, // 64 Mb
+		held:         make(map[*points.Points]uint64),
+		refs:         make(map[uint64]int),
+	}
+}
+
+// SetSyncInterval sets interval of flush and fsync journal to disk
+func (j *Journal) SetSyncInterval(interval time.Duration) {
+	j.syncInterval = interval
+}
+
+// SetSegmentSize sets maximum size of one segment file (in bytes)
+func (j *Journal) SetSegmentSize(size int64) {
+	j.segmentSize = size
+}
+
+func (j *Journal) segmentFilename(segment uint64) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%020d%s", segment, journalSuffix))
+}
+
+// segments returns sorted list of segment ids in journal directory
+func (j *Journal) segments() ([]uint64, error) {
+	files, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []uint64
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), journalSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), journalSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		result = append(result, id)
+	}
+
+	sort.Sort(segmentSorter(result))
+	return result, nil
+}
+
+type segmentSorter []uint64
+
+func (v segmentSorter) Len() int           { return len(v) }
+func (v segmentSorter) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v segmentSorter) Less(i, j int) bool { return v[i] < v[j] }
+
+func (j *Journal) openSegment(segment uint64) error {
+	fd, err := os.OpenFile(j.segmentFilename(segment), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	j.segment = segment
+	j.fd = fd
+	j.writer = bufio.NewWriter(fd)
+	j.written = 0
+
+	return nil
+}
+
+func (j *Journal) writePoints(p *points.Points) {
+	n, err := p.WriteTo(j.writer)
+	j.written += n
+	if err != nil {
+		logrus.Errorf("[journal] write failed: %s", err.Error())
+		return
+	}
+	j.writtenPoints += uint32(len(p.Data))
+}
+
+// Open creates journal directory, replays old segments with callback and
+// rewrites replayed points to new segment. Must be called before any Write
+func (j *Journal) Open(replay func(p *points.Points)) error {
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		return err
+	}
+
+	old, err := j.segments()
+	if err != nil {
+		return err
+	}
+
+	var next uint64
+	if len(old) > 0 {
+		next = old[len(old)-1] + 1
+	}
+
+	if err = j.openSegment(next); err != nil {
+		return err
+	}
+
+	replayed := 0
+	for _, segment := range old {
+		fd, err := os.Open(j.segmentFilename(segment))
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(fd)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+			p, err := points.ParseText(line)
+			if err != nil { // unfinished last line after crash
+				logrus.Warningf("[journal] skip bad line in segment %d: %s", segment, err.Error())
+				continue
+			}
+			j.writePoints(p)
+			replay(p)
+			replayed++
+		}
+		err = scanner.Err()
+		fd.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = j.doSync(); err != nil {
+		return err
+	}
+
+	for _, segment := range old {
+		if err = os.Remove(j.segmentFilename(segment)); err != nil {
+			return err
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"segments": len(old),
+		"points":   replayed,
+	}).Info("[journal] replayed")
+
+	return nil
+}
+
+// Write appends points to current segment. Returns id of segment
+func (j *Journal) Write(p *points.Points) uint64 {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.writer == nil { // closed
+		return j.segment
+	}
+
+	j.writePoints(p)
+	return j.segment
+}
+
+// current returns id of current segment
+func (j *Journal) current() uint64 {
+	j.Lock()
+	defer j.Unlock()
+	return j.segment
+}
+
+// hold marks cache record. Segments since given are kept until record released
+func (j *Journal) hold(p *points.Points, segment uint64) {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.writer == nil { // not opened or closed
+		return
+	}
+	if held, exists := j.held[p]; exists {
+		if held <= segment {
+			return
+		}
+		// record got points of older segment
+		j.refs[held]--
+		if j.refs[held] <= 0 {
+			delete(j.refs, held)
+		}
+	}
+	j.held[p] = segment
+	j.refs[segment]++
+}
+
+// segmentOf returns segment held by cache record or current segment
+func (j *Journal) segmentOf(p *points.Points) uint64 {
+	j.Lock()
+	defer j.Unlock()
+
+	if segment, exists := j.held[p]; exists {
+		return segment
+	}
+	return j.segment
+}
+
+// release record confirmed by persister
+func (j *Journal) release(p *points.Points) {
+	j.Lock()
+	defer j.Unlock()
+
+	segment, exists := j.held[p]
+	if !exists {
+		return
+	}
+	delete(j.held, p)
+
+	j.refs[segment]--
+	if j.refs[segment] <= 0 {
+		delete(j.refs, segment)
+	}
+}
+
+// sync flushes written points to disk
+func (j *Journal) sync() error {
+	j.Lock()
+	defer j.Unlock()
+	return j.doSync()
+}
+
+func (j *Journal) doSync() error {
+	if j.writer == nil {
+		return nil
+	}
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	return j.fd.Sync()
+}
+
+// cleanup removes segments older than any held cache record
+func (j *Journal) cleanup() {
+	minHeld := j.segment
+	for segment := range j.refs {
+		if segment < minHeld {
+			minHeld = segment
+		}
+	}
+
+	segments, err := j.segments()
+	if err != nil {
+		logrus.Errorf("[journal] %s", err.Error())
+		return
+	}
+
+	for _, segment := range segments {
+		if segment >= minHeld {
+			break
+		}
+		if err := os.Remove(j.segmentFilename(segment)); err != nil {
+			logrus.Errorf("[journal] %s", err.Error())
+			continue
+		}
+		j.removed++
+	}
+}
+
+// rotate starts new segment if current is full
+func (j *Journal) rotate() error {
+	if j.writer == nil || j.written < j.segmentSize {
+		return nil
+	}
+
+	if err := j.doSync(); err != nil {
+		return err
+	}
+	j.fd.Close()
+
+	return j.openSegment(j.segment + 1)
+}
+
+func (j *Journal) checkpoint() {
+	j.Lock()
+	defer j.Unlock()
+
+	if err := j.doSync(); err != nil {
+		logrus.Errorf("[journal] sync failed: %s", err.Error())
+	}
+
+	if err := j.rotate(); err != nil {
+		logrus.Errorf("[journal] rotate failed: %s", err.Error())
+	}
+
+	j.cleanup()
+}
+
+// Close flushes journal. All segments are removed if all points are confirmed
+func (j *Journal) Close() {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.writer == nil {
+		return
+	}
+
+	if err := j.doSync(); err != nil {
+		logrus.Errorf("[journal] sync failed: %s", err.Error())
+	}
+	j.fd.Close()
+	j.fd = nil
+	j.writer = nil
+
+	if len(j.refs) == 0 {
+		j.segment++ // current segment is not needed too
+	}
+	j.cleanup()
+}
+
+// Purge removes all segments. Called when points are saved somewhere else
+func (j *Journal) Purge() {
+	j.Lock()
+	defer j.Unlock()
+
+	j.held = make(map[*points.Points]uint64)
+	j.refs = make(map[uint64]int)
+
+	segments, err := j.segments()
+	if err != nil {
+		logrus.Errorf("[journal] %s", err.Error())
+		return
+	}
+
+	for _, segment := range segments {
+		if segment == j.segment && j.writer != nil {
+			continue // still open
+		}
+		if err := os.Remove(j.segmentFilename(segment)); err != nil {
+			logrus.Errorf("[journal] %s", err.Error())
+		}
+	}
+}
+
+// stat returns and resets internal counters
+func (j *Journal) stat() (writtenPoints uint32, removed uint32, held int) {
+	j.Lock()
+	defer j.Unlock()
+
+	writtenPoints, removed, held = j.writtenPoints, j.removed, len(j.held)
+	j.writtenPoints, j.removed = 0, 0
+	return
+}
+
+func (j *Journal) worker(exit chan bool) {
+	ticker := time.NewTicker(j.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.checkpoint()
+		case <-exit:
+			j.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		c := New()
+		c.SetJournal(NewJournal(root))
+		assert.NoError(c.Start())
+
+		c.In() <- points.OnePoint("hello.world", 42, 10)
+		c.In() <- points.OnePoint("hello.world", 15, 12)
+		c.In() <- points.OnePoint("metric.name", -1.5, 10)
+
+		time.Sleep(50 * time.Millisecond)
+
+		c.Stop() // crash without flush
+
+		restored := New()
+		restored.SetJournal(NewJournal(root))
+		assert.NoError(restored.Start())
+		defer restored.Stop()
+
+		for {
+			p := <-restored.Out()
+			if p.Metric == "hello.world" {
+				assert.Equal(2, len(p.Data))
+				break
+			}
+		}
+	})
+}
+
+func TestJournalCleanup(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		j := NewJournal(root)
+		j.SetSegmentSize(1)
+		assert.NoError(j.Open(func(p *points.Points) {}))
+
+		p1 := points.OnePoint("hello.world", 42, 10)
+		j.hold(p1, j.Write(p1))
+		j.checkpoint() // rotate
+
+		p2 := points.OnePoint("metric.name", 15, 10)
+		j.hold(p2, j.Write(p2))
+		j.checkpoint() // rotate
+
+		segments, err := j.segments()
+		assert.NoError(err)
+		assert.Equal([]uint64{0, 1, 2}, segments)
+
+		j.release(p2)
+		j.checkpoint()
+
+		segments, err = j.segments()
+		assert.NoError(err)
+		assert.Equal([]uint64{0, 1, 2}, segments) // p1 still not confirmed
+
+		j.release(p1)
+		j.checkpoint()
+
+		segments, err = j.segments()
+		assert.NoError(err)
+		assert.Equal([]uint64{2}, segments)
+
+		j.Close()
+
+		segments, err = j.segments()
+		assert.NoError(err)
+		assert.Empty(segments)
+	})
+}
+
+func TestJournalRequeue(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		c := New()
+		j := NewJournal(root)
+		j.SetSegmentSize(1)
+		assert.NoError(j.Open(func(p *points.Points) {}))
+		c.SetJournal(j)
+
+		p1 := points.OnePoint("hello.world", 42, 10)
+		c.add(p1, j.Write(p1))
+		j.checkpoint() // rotate
+
+		popped := c.Pop()
+		p2 := points.OnePoint("hello.world", 15, 20)
+		c.add(p2, j.Write(p2))
+
+		// requeued points keep older segment of record
+		c.Requeue(popped, time.Now())
+		j.release(popped)
+		assert.Equal(map[*points.Points]uint64{p2: 0}, j.held)
+
+		j.Close()
+	})
+}
+
+func TestJournalDump(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		c := New()
+		c.SetJournal(NewJournal(root))
+		assert.NoError(c.Start())
+
+		c.In() <- points.OnePoint("hello.world", 42, 10)
+		time.Sleep(50 * time.Millisecond)
+		c.Stop()
+
+		// journal is kept until dump is saved
+		assert.NoError(c.Dump(new(bytes.Buffer)))
+		segments, err := c.journal.segments()
+		assert.NoError(err)
+		assert.NotEmpty(segments)
+
+		c.PurgeJournal()
+		segments, err = c.journal.segments()
+		assert.NoError(err)
+		assert.Empty(segments)
+	})
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// MergeStrategy defines how points of one metric inside the same whisper step are merged in cache
+type MergeStrategy int
+
+const (
+	// MergeNone keeps all received points
+	MergeNone MergeStrategy = iota
+	// MergeLast keeps last received point of step. Points of one metric pass every pipeline stage
+	// in order of receiving, points of different connections are ordered by arrival to cache
+	MergeLast
+	// MergeAggregation merges points of step with aggregation method of metric
+	MergeAggregation
+)
+
+// ParseMergeStrategy returns MergeStrategy by config value: "none", "last" or "aggregation"
+func ParseMergeStrategy(value string) (MergeStrategy, error) {
+	switch value {
+	case "none", "":
+		return MergeNone, nil
+	case "last":
+		return MergeLast, nil
+	case "aggregation":
+		return MergeAggregation, nil
+	}
+	return MergeNone, fmt.Errorf("unknown cache merge strategy %#v", value)
+}
+
+// MergeFunc returns step in seconds of the highest precision retention and aggregation method
+// ("average", "sum", "last", "max" or "min") of metric. Zero step disables merge for metric
+type MergeFunc func(metric string) (step int64, method string)
+
+// mergeRule is MergeFunc result saved for cached metric
+type mergeRule struct {
+	step   int64
+	method string
+}
+
+// merge returns value of point produced from old and new values. Counts are numbers of received
+// points merged into each value, used by average
+func (r mergeRule) merge(old float64, oldCount int, value float64, count int) float64 {
+	switch r.method {
+	case "average":
+		return (old*float64(oldCount) + value*float64(count)) / float64(oldCount+count)
+	case "sum":
+		return old + value
+	case "max":
+		return math.Max(old, value)
+	case "min":
+		return math.Min(old, value)
+	}
+	return value
+}
+
+// SetMergeStrategy enables merge of points inside the same step. Call before Start()
+func (c *Cache) SetMergeStrategy(strategy MergeStrategy) {
+	c.mergeStrategy = strategy
+}
+
+// SetMergeFunc sets source of steps and aggregation methods of metrics. Safe to call on running cache
+func (c *Cache) SetMergeFunc(f MergeFunc) {
+	c.mergeFunc.Store(f)
+}
+
+// mergeRule returns rule for new cached metric. Zero step disables merge
+func (c *Cache) mergeRule(metric string) mergeRule {
+	if c.mergeStrategy == MergeNone {
+		return mergeRule{}
+	}
+	f, _ := c.mergeFunc.Load().(MergeFunc)
+	if f == nil {
+		return mergeRule{}
+	}
+	step, method := f(metric)
+	if step <= 0 {
+		return mergeRule{}
+	}
+	if c.mergeStrategy == MergeLast {
+		method = "last"
+	}
+	return mergeRule{step: step, method: method}
+}
+
+// merge adds point to values or merges it with cached point of the same step.
+// Merged points are replaced, never modified, because popped and queried data shares them.
+// Returns true if point was merged
+func (s *shard) merge(values *points.Points, p *points.Point, rule mergeRule) bool {
+	step := p.Timestamp - p.Timestamp%rule.step
+	for i := len(values.Data) - 1; i >= 0; i-- {
+		old := values.Data[i]
+		if old.Timestamp-old.Timestamp%rule.step != step {
+			continue
+		}
+		count := s.mergeCount[old] + 1
+		merged := &points.Point{
+			Value:     rule.merge(old.Value, count, p.Value, 1),
+			Timestamp: p.Timestamp,
+		}
+		values.Data[i] = merged
+		if rule.method == "average" {
+			delete(s.mergeCount, old)
+			s.mergeCount[merged] = count
+		}
+		return true
+	}
+	values.Data = append(values.Data, p)
+	return false
+}
+
+// requeue puts popped points in front of cached values. Popped point is merged with cached point
+// of the same step as the older one. Returns number of merged points
+func (s *shard) requeue(values *points.Points, data []*points.Point, rule mergeRule) int {
+	var merged int
+	older := make([]*points.Point, 0, len(data)+len(values.Data))
+	for _, p := range data {
+		if rule.step > 0 && s.mergeOlder(values, p, rule) {
+			merged++
+			continue
+		}
+		if count, exists := s.mergeCount[p]; exists {
+			// merge count of popped point is released on confirm
+			p = &points.Point{Value: p.Value, Timestamp: p.Timestamp}
+			s.mergeCount[p] = count
+		}
+		older = append(older, p)
+	}
+	values.Data = append(older, values.Data...)
+	return merged
+}
+
+// mergeOlder merges popped point into cached point of the same step. Returns false if values
+// have no point of the step
+func (s *shard) mergeOlder(values *points.Points, p *points.Point, rule mergeRule) bool {
+	step := p.Timestamp - p.Timestamp%rule.step
+	for i := len(values.Data) - 1; i >= 0; i-- {
+		newer := values.Data[i]
+		if newer.Timestamp-newer.Timestamp%rule.step != step {
+			continue
+		}
+		oldCount, count := s.mergeCount[p]+1, s.mergeCount[newer]+1
+		merged := &points.Point{
+			Value:     rule.merge(p.Value, oldCount, newer.Value, count),
+			Timestamp: newer.Timestamp,
+		}
+		values.Data[i] = merged
+		if rule.method == "average" {
+			delete(s.mergeCount, newer)
+			s.mergeCount[merged] = oldCount + count - 1
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func mergeValues(c *Cache, metric string) []float64 {
+	q := NewQuery(metric)
+	c.query(q)
+	result := make([]float64, 0)
+	for _, p := range q.CacheData.Data {
+		result = append(result, p.Value)
+	}
+	return result
+}
+
+func TestCacheMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	methods := map[string]string{
+		"avg": "average",
+		"sum": "sum",
+		"max": "max",
+		"min": "min",
+		"lst": "last",
+	}
+
+	c := New()
+	c.SetMergeStrategy(MergeAggregation)
+	c.SetMergeFunc(func(metric string) (int64, string) {
+		if method, ok := methods[metric]; ok {
+			return 60, method
+		}
+		return 0, ""
+	})
+
+	for _, metric := range []string{"avg", "sum", "max", "min", "lst", "other"} {
+		c.Add(points.OnePoint(metric, 2, 120))
+		c.Add(points.OnePoint(metric, 6, 130))
+		c.Add(points.OnePoint(metric, 5, 180))
+		c.Add(points.OnePoint(metric, 1, 179))
+	}
+
+	assert.Equal([]float64{3, 5}, mergeValues(c, "avg"))
+	assert.Equal([]float64{9, 5}, mergeValues(c, "sum"))
+	assert.Equal([]float64{6, 5}, mergeValues(c, "max"))
+	assert.Equal([]float64{1, 5}, mergeValues(c, "min"))
+	assert.Equal([]float64{1, 5}, mergeValues(c, "lst"))
+	assert.Equal([]float64{2, 6, 5, 1}, mergeValues(c, "other"))
+
+	assert.Equal(14, c.Size())
+	assert.Equal(uint32(10), c.mergedCnt)
+
+	// merge state is removed with popped metric and confirmed points
+	for values := c.Pop(); values != nil; values = c.Pop() {
+		c.release(values)
+	}
+	assert.Equal(0, c.Size())
+	for _, s := range c.shards {
+		assert.Len(s.mergeRules, 0)
+		assert.Len(s.mergeCount, 0)
+	}
+}
+
+func TestCacheMergeLast(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMergeStrategy(MergeLast)
+	c.SetMergeFunc(func(metric string) (int64, string) {
+		return 10, "sum"
+	})
+
+	c.Add(&points.Points{
+		Metric: "hello.world",
+		Data: []*points.Point{
+			{Value: 1, Timestamp: 10},
+			{Value: 2, Timestamp: 15},
+			{Value: 3, Timestamp: 20},
+		},
+	})
+
+	// queried data is not changed by following merge
+	q := NewQuery("hello.world")
+	c.query(q)
+
+	c.Add(points.OnePoint("hello.world", 4, 29))
+
+	assert.Equal([]float64{2, 3}, []float64{q.CacheData.Data[0].Value, q.CacheData.Data[1].Value})
+	assert.Equal([]float64{2, 4}, mergeValues(c, "hello.world"))
+	assert.Equal(2, c.Size())
+}
+
+func TestCacheMergeRequeue(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMergeStrategy(MergeAggregation)
+	c.SetMergeFunc(func(metric string) (int64, string) {
+		if metric == "avg" {
+			return 60, "average"
+		}
+		return 60, "last"
+	})
+
+	// popped points are sent to persister
+	pop := func() *points.Points {
+		values := c.Pop()
+		c.sent(values)
+		return values
+	}
+
+	// requeued point is older than cached point of the same step
+	c.Add(points.OnePoint("lst", 1, 120))
+	popped := pop()
+	c.Add(points.OnePoint("lst", 2, 130))
+	c.Requeue(popped, time.Now())
+	assert.Equal([]float64{2}, mergeValues(c, "lst"))
+	assert.Equal(int64(130), pop().Data[0].Timestamp)
+
+	// requeued points without cached point of step are put in front
+	c.Add(points.OnePoint("lst", 1, 60))
+	popped = pop()
+	c.Add(points.OnePoint("lst", 2, 130))
+	c.Requeue(popped, time.Now())
+	assert.Equal([]float64{1, 2}, mergeValues(c, "lst"))
+	pop()
+
+	// average keeps weight of popped point
+	c.Add(points.OnePoint("avg", 2, 120))
+	c.Add(points.OnePoint("avg", 4, 130))
+	popped = pop()
+	c.Add(points.OnePoint("avg", 6, 140))
+	c.Requeue(popped, time.Now())
+	c.release(popped)
+	c.Add(points.OnePoint("avg", 8, 150))
+	assert.Equal([]float64{5}, mergeValues(c, "avg"))
+
+	c.release(pop())
+	for _, s := range c.shards {
+		assert.Len(s.mergeCount, 0)
+	}
+}
+
+func TestParseMergeStrategy(t *testing.T) {
+	assert := assert.New(t)
+
+	for value, expected := range map[string]MergeStrategy{
+		"none":        MergeNone,
+		"last":        MergeLast,
+		"aggregation": MergeAggregation,
+	} {
+		strategy, err := ParseMergeStrategy(value)
+		assert.NoError(err)
+		assert.Equal(expected, strategy)
+	}
+
+	_, err := ParseMergeStrategy("first")
+	assert.Error(err)
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// OverflowPolicy defines what to do with received points when cache is full
+type OverflowPolicy int
+
+const (
+	// DropNewest drops all received points
+	DropNewest OverflowPolicy = iota
+	// DropLargest drops received points of metrics with many cached points
+	DropLargest
+	// Block stops receiving until cache has free space. Receivers are blocked too
+	Block
+)
+
+// ParseOverflowPolicy returns OverflowPolicy by config value: "drop-newest", "drop-largest" or "block"
+func ParseOverflowPolicy(value string) (OverflowPolicy, error) {
+	switch value {
+	case "drop-newest":
+		return DropNewest, nil
+	case "drop-largest":
+		return DropLargest, nil
+	case "block":
+		return Block, nil
+	}
+	return DropNewest, fmt.Errorf("unknown cache overflow policy %#v", value)
+}
+
+// hardLimitFactor of max-size and max-memory-bytes. Over it drop-largest policy drops all received points
+const hardLimitFactor = 2
+
+// maxRejectedMetrics limits count of metrics in rejected list
+const maxRejectedMetrics = 10000
+
+// rejectedList counts dropped points per metric
+type rejectedList struct {
+	sync.Mutex
+	data map[string]uint64
+}
+
+func (r *rejectedList) add(p *points.Points) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.data == nil {
+		r.data = make(map[string]uint64)
+	}
+
+	if _, exists := r.data[p.Metric]; !exists && len(r.data) >= maxRejectedMetrics {
+		return
+	}
+	r.data[p.Metric] += uint64(len(p.Data))
+}
+
+// SetOverflowPolicy sets behavior of cache when it is full. Call before Start()
+func (c *Cache) SetOverflowPolicy(policy OverflowPolicy) {
+	c.overflowPolicy = policy
+}
+
+// SetOverflowMaxPoints sets count of cached points of one metric after which received points
+// are dropped by DropLargest policy
+func (c *Cache) SetOverflowMaxPoints(count int) {
+	c.overflowMaxPoints = count
+}
+
+// count returns number of cached points of metric
+func (c *Cache) count(metric string) int {
+	s := c.shard(metric)
+	s.Lock()
+	defer s.Unlock()
+
+	if values, exists := s.data[metric]; exists {
+		return len(values.Data)
+	}
+	return 0
+}
+
+// accept applies overflow policy to received points. Returns false if points are dropped
+func (c *Cache) accept(p *points.Points, exit chan bool) bool {
+	if !c.isFull() {
+		return true
+	}
+
+	switch c.overflowPolicy {
+	case DropLargest:
+		if !c.overLimit(hardLimitFactor) && c.count(p.Metric) < c.overflowMaxPoints {
+			return true
+		}
+	case Block:
+		atomic.AddUint32(&c.blockedCnt, 1)
+		for c.isFull() {
+			select {
+			case <-exit: // keep points in cache for Dump
+				return true
+			case <-c.freeChan:
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	atomic.AddUint32(&c.overflowCnt, 1)
+	c.rejected.add(p)
+	return false
+}
+
+// Rejected returns count of dropped points by metric since start.
+// List is limited by 10000 metrics
+func (c *Cache) Rejected() map[string]uint64 {
+	c.rejected.Lock()
+	defer c.rejected.Unlock()
+
+	result := make(map[string]uint64, len(c.rejected.data))
+	for metric, count := range c.rejected.data {
+		result[metric] = count
+	}
+	return result
+}
+
+// RejectedHandler replies with json of dropped points by metric
+func (c *Cache) RejectedHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(c.Rejected())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflowDropNewest(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMaxSize(1)
+
+	c.Add(points.OnePoint("hello.world", 42, 10))
+
+	assert.False(c.accept(points.OnePoint("metric.name", 1, 10), nil))
+	assert.False(c.accept(points.OnePoint("metric.name", 2, 20), nil))
+	assert.Equal(map[string]uint64{"metric.name": 2}, c.Rejected())
+}
+
+func TestOverflowDropLargest(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMaxSize(2)
+	c.SetOverflowPolicy(DropLargest)
+	c.SetOverflowMaxPoints(2)
+
+	c.Add(points.OnePoint("hello.world", 42, 10))
+
+	assert.True(c.accept(points.OnePoint("hello.world", 15, 20), nil))
+	c.Add(points.OnePoint("hello.world", 15, 20))
+
+	assert.False(c.accept(points.OnePoint("hello.world", 1, 30), nil))
+	assert.True(c.accept(points.OnePoint("metric.name", 1, 10), nil))
+	assert.Equal(map[string]uint64{"hello.world": 1}, c.Rejected())
+
+	// new metrics are dropped over hard limit
+	c.Add(points.OnePoint("metric.name", 1, 10))
+	c.Add(points.OnePoint("metric.name", 1, 20))
+	assert.False(c.accept(points.OnePoint("new.metric", 1, 10), nil))
+	assert.Equal(map[string]uint64{"hello.world": 1, "new.metric": 1}, c.Rejected())
+}
+
+func TestOverflowBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMaxSize(1)
+	c.SetOverflowPolicy(Block)
+
+	c.Add(points.OnePoint("hello.world", 42, 10))
+
+	accepted := make(chan bool)
+	go func() {
+		accepted <- c.accept(points.OnePoint("metric.name", 1, 10), nil)
+	}()
+
+	select {
+	case <-accepted:
+		t.Fatal("not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Pop()
+
+	select {
+	case ok := <-accepted:
+		assert.True(ok)
+	case <-time.After(time.Second):
+		t.Fatal("still blocked")
+	}
+
+	assert.Empty(c.Rejected())
+}
+
+func TestRejectedHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New()
+	c.SetMaxSize(1)
+	c.Add(points.OnePoint("hello.world", 42, 10))
+	c.accept(points.OnePoint("metric.name", 1, 10), nil)
+
+	w := httptest.NewRecorder()
+	c.RejectedHandler(w, httptest.NewRequest("GET", "/cache/rejected", nil))
+
+	assert.Equal(200, w.Code)
+	assert.Equal(`{"metric.name":1}`, w.Body.String())
+}
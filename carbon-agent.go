@@ -24,7 +24,7 @@ import _ "net/http/pprof"
 // Version of go-carbon
 const Version = "0.7.2"
 
-func httpServe(addr string) (func(), error) {
+func httpServe(addr string, handler http.Handler) (func(), error) {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -35,7 +35,7 @@ func httpServe(addr string) (func(), error) {
 		return nil, err
 	}
 
-	go http.Serve(listener, nil)
+	go http.Serve(listener, handler)
 	return func() { listener.Close() }, nil
 }
 
@@ -143,7 +143,7 @@ func main() {
 	// pprof
 	httpStop := func() {}
 	if cfg.Pprof.Enabled {
-		httpStop, err = httpServe(cfg.Pprof.Listen)
+		httpStop, err = httpServe(cfg.Pprof.Listen, nil)
 		if err != nil {
 			logrus.Fatal(err)
 		}
@@ -155,11 +155,23 @@ func main() {
 		logrus.Info("started")
 	}
 
+	// admin
+	adminStop := func() {}
+	if cfg.Admin.Enabled {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/cache/rejected", app.Cache.RejectedHandler)
+		adminStop, err = httpServe(cfg.Admin.Listen, mux)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGUSR2)
 		<-c
 		httpStop()
+		adminStop()
 		app.GraceStop()
 	}()
 
@@ -4,14 +4,21 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/lomik/go-carbon/cache"
+	"github.com/lomik/go-carbon/carbonserver"
+	"github.com/lomik/go-carbon/filter"
+	"github.com/lomik/go-carbon/helper"
 	"github.com/lomik/go-carbon/persister"
+	"github.com/lomik/go-carbon/points"
 	"github.com/lomik/go-carbon/receiver"
+	"github.com/lomik/go-carbon/rewrite"
 )
 
 type App struct {
@@ -21,8 +28,20 @@ type App struct {
 	Cache          *cache.Cache
 	UDP            *receiver.UDP
 	TCP            *receiver.TCP
+	TCPListeners   []*receiver.TCP // [[tcp-listener]] sections
+	tcpListenAddrs []string        // listen options of TCPListeners
 	Pickle         *receiver.TCP
+	Protobuf       *receiver.TCP
+	HTTP           *receiver.HTTP
+	StatsD         *receiver.StatsD
+	Influx         *receiver.Influx
+	OpenTSDB       *receiver.TCP
+	Prometheus     *receiver.Prometheus
+	Collectd       *receiver.Collectd
+	Rewriter       *rewrite.Rewriter
+	Filter         *filter.Filter
 	CarbonLink     *cache.CarbonlinkListener
+	Carbonserver   *carbonserver.CarbonserverListener
 	Persister      *persister.Whisper
 	exit           chan bool
 }
@@ -70,6 +89,27 @@ func (app *App) configure() error {
 		}
 	}
 
+	if cfg.Rewrite.Enabled {
+		cfg.Rewrite.Rules, err = rewrite.ReadRules(cfg.Rewrite.RulesFilename)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Filter.Enabled {
+		cfg.Filter.Rules, err = filter.ReadRules(cfg.Filter.RulesFilename)
+		if err != nil {
+			return err
+		}
+		cfg.Filter.Rules.Default, err = filter.ParseAction(cfg.Filter.DefaultAction)
+		if err != nil {
+			return err
+		}
+	} else {
+		// filter stage is used by validation only
+		cfg.Filter.Rules = &filter.Rules{}
+	}
+
 	app.Config = cfg
 
 	return nil
@@ -99,6 +139,64 @@ func (app *App) ReloadConfig() error {
 	}
 	app.startPersister()
 
+	if app.Cache != nil && app.Config.Whisper.Enabled {
+		app.Cache.SetMergeFunc(persister.MergeRule(app.Config.Whisper.Schemas, app.Config.Whisper.Aggregation))
+	}
+
+	if app.Rewriter != nil {
+		app.Rewriter.SetRules(app.Config.Rewrite.Rules)
+	}
+
+	if app.Filter != nil {
+		app.Filter.SetRules(app.Config.Filter.Rules)
+	}
+
+	// new certificates are used for new connections. All listeners are reloaded even if one fails
+	var errs []string
+	reloaded := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	}
+
+	conf := app.Config
+
+	if app.TCP != nil {
+		reloaded("tcp", app.TCP.ReloadTLS(conf.Tcp.TLSCert, conf.Tcp.TLSKey, conf.Tcp.TLSCA))
+	}
+
+	for i, tcpListener := range app.TCPListeners {
+		name := fmt.Sprintf("tcp-listener %s", app.tcpListenAddrs[i])
+		tcpConf := findTCPListener(conf.TcpListeners, app.tcpListenAddrs[i])
+		if tcpConf == nil {
+			reloaded(name, fmt.Errorf("section not found, restart required"))
+			continue
+		}
+		reloaded(name, tcpListener.ReloadTLS(tcpConf.TLSCert, tcpConf.TLSKey, tcpConf.TLSCA))
+	}
+
+	if app.Pickle != nil {
+		reloaded("pickle", app.Pickle.ReloadTLS(conf.Pickle.TLSCert, conf.Pickle.TLSKey, conf.Pickle.TLSCA))
+	}
+
+	if app.CarbonLink != nil {
+		reloaded("carbonlink", app.CarbonLink.ReloadTLS(conf.Carbonlink.TLSCert, conf.Carbonlink.TLSKey, conf.Carbonlink.TLSCA))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("TLS reload failed: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// findTCPListener returns enabled [[tcp-listener]] section by listen option
+func findTCPListener(list []*tcpConfig, listen string) *tcpConfig {
+	for _, tcpConf := range list {
+		if tcpConf.Enabled && tcpConf.Listen == listen {
+			return tcpConf
+		}
+	}
 	return nil
 }
 
@@ -110,6 +208,57 @@ func (app *App) stopListeners() {
 		logrus.Debug("[tcp] finished")
 	}
 
+	for _, tcpListener := range app.TCPListeners {
+		tcpListener.Stop()
+	}
+	if app.TCPListeners != nil {
+		app.TCPListeners = nil
+		app.tcpListenAddrs = nil
+		logrus.Debug("[tcp-listener] finished")
+	}
+
+	if app.Protobuf != nil {
+		app.Protobuf.Stop()
+		app.Protobuf = nil
+		logrus.Debug("[protobuf] finished")
+	}
+
+	if app.HTTP != nil {
+		app.HTTP.Stop()
+		app.HTTP = nil
+		logrus.Debug("[http] finished")
+	}
+
+	if app.StatsD != nil {
+		app.StatsD.Stop()
+		app.StatsD = nil
+		logrus.Debug("[statsd] finished")
+	}
+
+	if app.Influx != nil {
+		app.Influx.Stop()
+		app.Influx = nil
+		logrus.Debug("[influx] finished")
+	}
+
+	if app.OpenTSDB != nil {
+		app.OpenTSDB.Stop()
+		app.OpenTSDB = nil
+		logrus.Debug("[opentsdb] finished")
+	}
+
+	if app.Prometheus != nil {
+		app.Prometheus.Stop()
+		app.Prometheus = nil
+		logrus.Debug("[prometheus] finished")
+	}
+
+	if app.Collectd != nil {
+		app.Collectd.Stop()
+		app.Collectd = nil
+		logrus.Debug("[collectd] finished")
+	}
+
 	if app.Pickle != nil {
 		app.Pickle.Stop()
 		app.Pickle = nil
@@ -127,6 +276,25 @@ func (app *App) stopListeners() {
 		app.CarbonLink = nil
 		logrus.Debug("[carbonlink] finished")
 	}
+
+	if app.Carbonserver != nil {
+		app.Carbonserver.Stop()
+		app.Carbonserver = nil
+		logrus.Debug("[carbonserver] finished")
+	}
+
+	// after all receivers
+	if app.Rewriter != nil {
+		app.Rewriter.Stop()
+		app.Rewriter = nil
+		logrus.Debug("[rewrite] finished")
+	}
+
+	if app.Filter != nil {
+		app.Filter.Stop()
+		app.Filter = nil
+		logrus.Debug("[filter] finished")
+	}
 }
 
 func (app *App) stopAll() {
@@ -158,7 +326,86 @@ func (app *App) Stop() {
 	app.stopAll()
 }
 
-// GraceStop implements gracefully stop. Close all listening sockets, flush cache, stop application
+func (app *App) dumpFilename() string {
+	return filepath.Join(app.Config.Dump.Path, "cache.dump")
+}
+
+// dumpCache stops persister and cache and saves all not persisted points to dump file
+func (app *App) dumpCache() error {
+	filename := app.dumpFilename()
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	fd, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	dumpStart := time.Now()
+
+	if app.Persister != nil {
+		app.Persister.Stop()
+		app.Persister = nil
+		logrus.Debug("[persister] finished")
+	}
+
+	app.Cache.Stop()
+
+	if err = app.Cache.Dump(fd); err != nil {
+		return err
+	}
+
+	if err = fd.Sync(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(filename+".tmp", filename); err != nil {
+		return err
+	}
+
+	// dump contains all points from journal
+	app.Cache.PurgeJournal()
+
+	logrus.WithFields(logrus.Fields{
+		"time": time.Now().Sub(dumpStart).String(),
+		"file": filename,
+	}).Info("[cache] finish dump")
+
+	return nil
+}
+
+// restoreCache loads points from dump file to cache. Dump file is removed by removeDump
+// after cache is started and restored points are journaled
+func (app *App) restoreCache(core *cache.Cache) error {
+	filename := app.dumpFilename()
+
+	fd, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fd.Close()
+
+	logrus.Infof("[cache] restore from %s", filename)
+
+	return core.Restore(fd)
+}
+
+// removeDump removes dump file loaded by restoreCache
+func (app *App) removeDump() error {
+	err := os.Remove(app.dumpFilename())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GraceStop implements gracefully stop. Close all listening sockets, flush or dump cache, stop application
 func (app *App) GraceStop() {
 	app.Lock()
 	defer app.Unlock()
@@ -167,6 +414,15 @@ func (app *App) GraceStop() {
 
 	app.stopListeners()
 
+	// Dump cache instead of flush
+	if app.Cache != nil && app.Config.Dump.Enabled {
+		if err := app.dumpCache(); err != nil {
+			logrus.Errorf("[cache] dump failed: %s", err.Error())
+		}
+		app.stopAll()
+		return
+	}
+
 	// Flush cache
 	if app.Cache != nil && app.Persister != nil {
 
@@ -229,8 +485,12 @@ func (app *App) startPersister() {
 		p.SetGraphPrefix(app.Config.Common.GraphPrefix)
 		p.SetMetricInterval(app.Config.Common.MetricInterval.Value())
 		p.SetMaxUpdatesPerSecond(app.Config.Whisper.MaxUpdatesPerSecond)
+		p.SetMaxCreatesPerSecond(app.Config.Whisper.MaxCreatesPerSecond)
+		p.SetRequeue(app.Cache.Requeue)
 		p.SetSparse(app.Config.Whisper.Sparse)
 		p.SetWorkers(app.Config.Whisper.Workers)
+		p.SetMaxOpenFiles(app.Config.Whisper.MaxOpenFiles)
+		p.SetOpenFilesIdleTimeout(app.Config.Whisper.OpenFilesIdle.Value())
 
 		p.Start()
 
@@ -238,6 +498,59 @@ func (app *App) startPersister() {
 	}
 }
 
+// input returns channel for receivers: receivers -> rewriter -> filter -> cache.
+// Disabled stages are skipped
+func (app *App) input() chan *points.Points {
+	if app.Rewriter != nil {
+		return app.Rewriter.In()
+	}
+	if app.Filter != nil {
+		return app.Filter.In()
+	}
+	return app.Cache.In()
+}
+
+var notAlnum = regexp.MustCompile("[^a-zA-Z0-9]+")
+
+// tcpListenerPrefix returns prefix of internal metrics of [[tcp-listener]]: "tcp_<name>." or "tcp_<listen address>."
+func tcpListenerPrefix(graphPrefix string, conf *tcpConfig) string {
+	name := conf.Name
+	if name == "" {
+		name = conf.Listen
+	}
+	return fmt.Sprintf("%stcp_%s.", graphPrefix, strings.Trim(notAlnum.ReplaceAllString(name, "_"), "_"))
+}
+
+// startTCP starts plain text listener configured by [tcp] or [[tcp-listener]] section
+func (app *App) startTCP(tcpConf *tcpConfig, graphPrefix string) (*receiver.TCP, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", tcpConf.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpListener := receiver.NewTCP(app.input())
+	tcpListener.SetGraphPrefix(graphPrefix)
+	tcpListener.SetMetricInterval(app.Config.Common.MetricInterval.Value())
+
+	if err = tcpListener.SetCompression(tcpConf.Compression); err != nil {
+		return nil, err
+	}
+
+	if tcpConf.TLSCert != "" {
+		tlsConfig, err := helper.NewTLSConfig(tcpConf.TLSCert, tcpConf.TLSKey, tcpConf.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		tcpListener.SetTLS(tlsConfig)
+	}
+
+	if err = tcpListener.Listen(tcpAddr); err != nil {
+		return nil, err
+	}
+
+	return tcpListener, nil
+}
+
 // Start starts
 func (app *App) Start() (err error) {
 	app.Lock()
@@ -255,11 +568,117 @@ func (app *App) Start() (err error) {
 	core.SetGraphPrefix(conf.Common.GraphPrefix)
 	core.SetMetricInterval(conf.Common.MetricInterval.Value())
 	core.SetMaxSize(conf.Cache.MaxSize)
+	core.SetMaxMemory(conf.Cache.MaxMemoryBytes)
 	core.SetInputCapacity(conf.Cache.InputBuffer)
-	core.Start()
+	core.SetShards(conf.Cache.Shards)
+
+	writeStrategy, err := cache.ParseWriteStrategy(conf.Cache.WriteStrategy)
+	if err != nil {
+		return
+	}
+	core.SetWriteStrategy(writeStrategy)
+
+	overflowPolicy, err := cache.ParseOverflowPolicy(conf.Cache.OverflowPolicy)
+	if err != nil {
+		return
+	}
+	core.SetOverflowPolicy(overflowPolicy)
+	core.SetOverflowMaxPoints(conf.Cache.OverflowMaxPoints)
+
+	mergeStrategy, err := cache.ParseMergeStrategy(conf.Cache.Merge)
+	if err != nil {
+		return
+	}
+	core.SetMergeStrategy(mergeStrategy)
+	if conf.Whisper.Enabled {
+		core.SetMergeFunc(persister.MergeRule(conf.Whisper.Schemas, conf.Whisper.Aggregation))
+	}
+
+	if conf.Dump.Enabled {
+		if err = app.restoreCache(core); err != nil {
+			return
+		}
+	}
+
+	if conf.Cache.JournalDir != "" {
+		journal := cache.NewJournal(conf.Cache.JournalDir)
+		journal.SetSyncInterval(conf.Cache.JournalSyncInterval.Value())
+		journal.SetSegmentSize(int64(conf.Cache.JournalSegmentSize))
+		core.SetJournal(journal)
+	}
+
+	if err = core.Start(); err != nil {
+		return
+	}
 
 	app.Cache = core
 
+	if conf.Dump.Enabled {
+		if err = app.removeDump(); err != nil {
+			return
+		}
+	}
+
+	/* FILTER start */
+	if conf.Filter.Enabled || conf.Validation.Enabled || conf.Timestamps.Enabled {
+		filterStage := filter.New(core.In())
+		filterStage.SetGraphPrefix(fmt.Sprintf("%sfilter.", conf.Common.GraphPrefix))
+		filterStage.SetMetricInterval(conf.Common.MetricInterval.Value())
+		filterStage.SetRules(conf.Filter.Rules)
+
+		if conf.Validation.Enabled {
+			validator := points.NewValidator()
+			validator.SetMaxLength(conf.Validation.MaxLength)
+
+			switch conf.Validation.Action {
+			case "reject":
+			case "normalize":
+				validator.SetNormalize(true)
+			default:
+				err = fmt.Errorf("unknown validation action %#v", conf.Validation.Action)
+				return
+			}
+
+			filterStage.SetValidator(validator)
+		}
+
+		if conf.Timestamps.Enabled {
+			var windowAction points.WindowAction
+			windowAction, err = points.ParseWindowAction(conf.Timestamps.Action)
+			if err != nil {
+				return
+			}
+
+			filterStage.SetTimestampWindow(points.NewTimestampWindow(
+				conf.Timestamps.MaxFuture.Value(),
+				conf.Timestamps.MaxPast.Value(),
+				windowAction,
+			))
+		}
+
+		if err = filterStage.Start(); err != nil {
+			return
+		}
+
+		app.Filter = filterStage
+	}
+	/* FILTER end */
+
+	/* REWRITE start */
+	if conf.Rewrite.Enabled {
+		rewriter := rewrite.New(app.input())
+		rewriter.SetGraphPrefix(fmt.Sprintf("%srewrite.", conf.Common.GraphPrefix))
+		rewriter.SetMetricInterval(conf.Common.MetricInterval.Value())
+		rewriter.SetRules(conf.Rewrite.Rules)
+
+		if err = rewriter.Start(); err != nil {
+			return
+		}
+
+		app.Rewriter = rewriter
+	}
+	/* REWRITE end */
+
 	/* WHISPER start */
 	app.startPersister()
 	/* WHISPER end */
@@ -273,7 +692,7 @@ func (app *App) Start() (err error) {
 			return
 		}
 
-		udpListener := receiver.NewUDP(core.In())
+		udpListener := receiver.NewUDP(app.input())
 		udpListener.SetGraphPrefix(fmt.Sprintf("%sudp.", conf.Common.GraphPrefix))
 		udpListener.SetMetricInterval(conf.Common.MetricInterval.Value())
 
@@ -292,21 +711,23 @@ func (app *App) Start() (err error) {
 
 	/* TCP start */
 	if conf.Tcp.Enabled {
-		var tcpAddr *net.TCPAddr
-		tcpAddr, err = net.ResolveTCPAddr("tcp", conf.Tcp.Listen)
-		if err != nil {
+		if app.TCP, err = app.startTCP(&conf.Tcp, fmt.Sprintf("%stcp.", conf.Common.GraphPrefix)); err != nil {
 			return
 		}
+	}
 
-		tcpListener := receiver.NewTCP(core.In())
-		tcpListener.SetGraphPrefix(fmt.Sprintf("%stcp.", conf.Common.GraphPrefix))
-		tcpListener.SetMetricInterval(conf.Common.MetricInterval.Value())
+	for _, tcpConf := range conf.TcpListeners {
+		if !tcpConf.Enabled {
+			continue
+		}
 
-		if err = tcpListener.Listen(tcpAddr); err != nil {
+		var tcpListener *receiver.TCP
+		tcpListener, err = app.startTCP(tcpConf, tcpListenerPrefix(conf.Common.GraphPrefix, tcpConf))
+		if err != nil {
 			return
 		}
-
-		app.TCP = tcpListener
+		app.TCPListeners = append(app.TCPListeners, tcpListener)
+		app.tcpListenAddrs = append(app.tcpListenAddrs, tcpConf.Listen)
 	}
 	/* TCP end */
 
@@ -319,11 +740,19 @@ func (app *App) Start() (err error) {
 			return
 		}
 
-		pickleListener := receiver.NewPickle(core.In())
+		pickleListener := receiver.NewPickle(app.input())
 		pickleListener.SetGraphPrefix(fmt.Sprintf("%spickle.", conf.Common.GraphPrefix))
 		pickleListener.SetMetricInterval(conf.Common.MetricInterval.Value())
 		pickleListener.SetMaxPickleMessageSize(uint32(conf.Pickle.MaxMessageSize))
 
+		if conf.Pickle.TLSCert != "" {
+			var tlsConfig *helper.TLSConfig
+			if tlsConfig, err = helper.NewTLSConfig(conf.Pickle.TLSCert, conf.Pickle.TLSKey, conf.Pickle.TLSCA); err != nil {
+				return
+			}
+			pickleListener.SetTLS(tlsConfig)
+		}
+
 		if err = pickleListener.Listen(pickleAddr); err != nil {
 			return
 		}
@@ -332,6 +761,186 @@ func (app *App) Start() (err error) {
 	}
 	/* PICKLE end */
 
+	/* PROTOBUF start */
+	if conf.Protobuf.Enabled {
+		var protobufAddr *net.TCPAddr
+		protobufAddr, err = net.ResolveTCPAddr("tcp", conf.Protobuf.Listen)
+		if err != nil {
+			return
+		}
+
+		protobufListener := receiver.NewProtobuf(app.input())
+		protobufListener.SetGraphPrefix(fmt.Sprintf("%sprotobuf.", conf.Common.GraphPrefix))
+		protobufListener.SetMetricInterval(conf.Common.MetricInterval.Value())
+		protobufListener.SetMaxPickleMessageSize(uint32(conf.Protobuf.MaxMessageSize))
+
+		if err = protobufListener.Listen(protobufAddr); err != nil {
+			return
+		}
+
+		app.Protobuf = protobufListener
+	}
+	/* PROTOBUF end */
+
+	/* HTTP start */
+	if conf.Http.Enabled {
+		var httpAddr *net.TCPAddr
+		httpAddr, err = net.ResolveTCPAddr("tcp", conf.Http.Listen)
+		if err != nil {
+			return
+		}
+
+		httpListener := receiver.NewHTTP(app.input())
+		httpListener.SetGraphPrefix(fmt.Sprintf("%shttp.", conf.Common.GraphPrefix))
+		httpListener.SetMetricInterval(conf.Common.MetricInterval.Value())
+		httpListener.SetMaxBodySize(int64(conf.Http.MaxBodySize))
+
+		if err = httpListener.Listen(httpAddr); err != nil {
+			return
+		}
+
+		app.HTTP = httpListener
+	}
+	/* HTTP end */
+
+	/* STATSD start */
+	if conf.Statsd.Enabled {
+		var statsdAddr *net.UDPAddr
+		statsdAddr, err = net.ResolveUDPAddr("udp", conf.Statsd.Listen)
+		if err != nil {
+			return
+		}
+
+		statsdListener := receiver.NewStatsD(app.input())
+		statsdListener.SetGraphPrefix(fmt.Sprintf("%sstatsd.", conf.Common.GraphPrefix))
+		statsdListener.SetMetricInterval(conf.Common.MetricInterval.Value())
+		statsdListener.SetPrefix(conf.Statsd.Prefix)
+		statsdListener.SetFlushInterval(conf.Statsd.FlushInterval.Value())
+		statsdListener.SetPercentiles(conf.Statsd.Percentiles)
+
+		if err = statsdListener.Listen(statsdAddr); err != nil {
+			return
+		}
+
+		app.StatsD = statsdListener
+	}
+	/* STATSD end */
+
+	/* INFLUXDB start */
+	if conf.Influx.Enabled {
+		var influxTCPAddr, influxHTTPAddr *net.TCPAddr
+		var influxUDPAddr *net.UDPAddr
+
+		if conf.Influx.ListenTCP != "" {
+			if influxTCPAddr, err = net.ResolveTCPAddr("tcp", conf.Influx.ListenTCP); err != nil {
+				return
+			}
+		}
+
+		if conf.Influx.ListenUDP != "" {
+			if influxUDPAddr, err = net.ResolveUDPAddr("udp", conf.Influx.ListenUDP); err != nil {
+				return
+			}
+		}
+
+		if conf.Influx.ListenHTTP != "" {
+			if influxHTTPAddr, err = net.ResolveTCPAddr("tcp", conf.Influx.ListenHTTP); err != nil {
+				return
+			}
+		}
+
+		influxListener := receiver.NewInflux(app.input())
+		influxListener.SetGraphPrefix(fmt.Sprintf("%sinflux.", conf.Common.GraphPrefix))
+		influxListener.SetMetricInterval(conf.Common.MetricInterval.Value())
+		influxListener.SetTagged(conf.Influx.Tagged)
+		influxListener.SetMaxBodySize(int64(conf.Influx.MaxBodySize))
+
+		if err = influxListener.SetTemplate(conf.Influx.Template); err != nil {
+			return
+		}
+
+		if err = influxListener.Listen(influxTCPAddr, influxUDPAddr, influxHTTPAddr); err != nil {
+			return
+		}
+
+		app.Influx = influxListener
+	}
+	/* INFLUXDB end */
+
+	/* OPENTSDB start */
+	if conf.OpenTSDB.Enabled {
+		var opentsdbAddr *net.TCPAddr
+		opentsdbAddr, err = net.ResolveTCPAddr("tcp", conf.OpenTSDB.Listen)
+		if err != nil {
+			return
+		}
+
+		opentsdbListener := receiver.NewOpenTSDB(app.input(), &points.OpenTSDBFormat{
+			TagOrder: conf.OpenTSDB.TagOrder,
+			Tagged:   conf.OpenTSDB.Tagged,
+		})
+		opentsdbListener.SetGraphPrefix(fmt.Sprintf("%sopentsdb.", conf.Common.GraphPrefix))
+		opentsdbListener.SetMetricInterval(conf.Common.MetricInterval.Value())
+
+		if err = opentsdbListener.Listen(opentsdbAddr); err != nil {
+			return
+		}
+
+		app.OpenTSDB = opentsdbListener
+	}
+	/* OPENTSDB end */
+
+	/* PROMETHEUS start */
+	if conf.Prometheus.Enabled {
+		var prometheusAddr *net.TCPAddr
+		prometheusAddr, err = net.ResolveTCPAddr("tcp", conf.Prometheus.Listen)
+		if err != nil {
+			return
+		}
+
+		prometheusListener := receiver.NewPrometheus(app.input())
+		prometheusListener.SetGraphPrefix(fmt.Sprintf("%sprometheus.", conf.Common.GraphPrefix))
+		prometheusListener.SetMetricInterval(conf.Common.MetricInterval.Value())
+		prometheusListener.SetMaxBodySize(int64(conf.Prometheus.MaxBodySize))
+		prometheusListener.SetMaxDecodedSize(conf.Prometheus.MaxDecodedSize)
+		prometheusListener.SetTagged(conf.Prometheus.Tagged)
+
+		if err = prometheusListener.Listen(prometheusAddr); err != nil {
+			return
+		}
+
+		app.Prometheus = prometheusListener
+	}
+	/* PROMETHEUS end */
+
+	/* COLLECTD start */
+	if conf.Collectd.Enabled {
+		var collectdAddr *net.UDPAddr
+		collectdAddr, err = net.ResolveUDPAddr("udp", conf.Collectd.Listen)
+		if err != nil {
+			return
+		}
+
+		collectdListener := receiver.NewCollectd(app.input())
+		collectdListener.SetGraphPrefix(fmt.Sprintf("%scollectd.", conf.Common.GraphPrefix))
+		collectdListener.SetMetricInterval(conf.Common.MetricInterval.Value())
+		collectdListener.SetPrefix(conf.Collectd.Prefix)
+		collectdListener.SetRate(conf.Collectd.Rate)
+
+		if conf.Collectd.TypesDB != "" {
+			if err = collectdListener.SetTypesDB(conf.Collectd.TypesDB); err != nil {
+				return
+			}
+		}
+
+		if err = collectdListener.Listen(collectdAddr); err != nil {
+			return
+		}
+
+		app.Collectd = collectdListener
+	}
+	/* COLLECTD end */
+
 	/* CARBONLINK start */
 	if conf.Carbonlink.Enabled {
 		var linkAddr *net.TCPAddr
@@ -344,6 +953,14 @@ func (app *App) Start() (err error) {
 		carbonlink.SetReadTimeout(conf.Carbonlink.ReadTimeout.Value())
 		carbonlink.SetQueryTimeout(conf.Carbonlink.QueryTimeout.Value())
 
+		if conf.Carbonlink.TLSCert != "" {
+			var tlsConfig *helper.TLSConfig
+			if tlsConfig, err = helper.NewTLSConfig(conf.Carbonlink.TLSCert, conf.Carbonlink.TLSKey, conf.Carbonlink.TLSCA); err != nil {
+				return
+			}
+			carbonlink.SetTLS(tlsConfig)
+		}
+
 		if err = carbonlink.Listen(linkAddr); err != nil {
 			return
 		}
@@ -352,6 +969,31 @@ func (app *App) Start() (err error) {
 	}
 	/* CARBONLINK end */
 
+	/* CARBONSERVER start */
+	if conf.Carbonserver.Enabled {
+		var serverAddr *net.TCPAddr
+		serverAddr, err = net.ResolveTCPAddr("tcp", conf.Carbonserver.Listen)
+		if err != nil {
+			return
+		}
+
+		server := carbonserver.NewCarbonserverListener(core.Query(), core.In())
+		server.SetWhisperData(conf.Whisper.DataDir)
+		server.SetGraphPrefix(fmt.Sprintf("%scarbonserver.", conf.Common.GraphPrefix))
+		server.SetMetricInterval(conf.Common.MetricInterval.Value())
+		server.SetReadTimeout(conf.Carbonserver.ReadTimeout.Value())
+		server.SetWriteTimeout(conf.Carbonserver.WriteTimeout.Value())
+		server.SetQueryTimeout(conf.Carbonserver.QueryTimeout.Value())
+		server.SetMaxGlobs(conf.Carbonserver.MaxGlobs)
+
+		if err = server.Listen(serverAddr); err != nil {
+			return
+		}
+
+		app.Carbonserver = server
+	}
+	/* CARBONSERVER end */
+
 	return
 }
 
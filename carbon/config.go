@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/lomik/go-carbon/filter"
 	"github.com/lomik/go-carbon/persister"
+	"github.com/lomik/go-carbon/rewrite"
 )
 
 // Duration wrapper time.Duration for TOML
@@ -45,20 +47,37 @@ type commonConfig struct {
 }
 
 type whisperConfig struct {
-	DataDir             string `toml:"data-dir"`
-	SchemasFilename     string `toml:"schemas-file"`
-	AggregationFilename string `toml:"aggregation-file"`
-	Workers             int    `toml:"workers"`
-	MaxUpdatesPerSecond int    `toml:"max-updates-per-second"`
-	Sparse              bool   `toml:"sparse-create"`
-	Enabled             bool   `toml:"enabled"`
+	DataDir             string    `toml:"data-dir"`
+	SchemasFilename     string    `toml:"schemas-file"`
+	AggregationFilename string    `toml:"aggregation-file"`
+	Workers             int       `toml:"workers"`
+	MaxUpdatesPerSecond int       `toml:"max-updates-per-second"`
+	MaxCreatesPerSecond int       `toml:"max-creates-per-second"`
+	Sparse              bool      `toml:"sparse-create"`
+	MaxOpenFiles        int       `toml:"max-open-files"`
+	OpenFilesIdle       *Duration `toml:"open-files-idle-timeout"`
+	Enabled             bool      `toml:"enabled"`
 	Schemas             persister.WhisperSchemas
 	Aggregation         *persister.WhisperAggregation
 }
 
 type cacheConfig struct {
-	MaxSize     int `toml:"max-size"`
-	InputBuffer int `toml:"input-buffer"`
+	MaxSize             int       `toml:"max-size"`
+	MaxMemoryBytes      int64     `toml:"max-memory-bytes"`
+	InputBuffer         int       `toml:"input-buffer"`
+	Shards              int       `toml:"shards"`
+	WriteStrategy       string    `toml:"write-strategy"`
+	OverflowPolicy      string    `toml:"overflow-policy"`
+	OverflowMaxPoints   int       `toml:"overflow-max-points"`
+	JournalDir          string    `toml:"journal-dir"`
+	JournalSyncInterval *Duration `toml:"journal-sync-interval"`
+	JournalSegmentSize  int       `toml:"journal-segment-size"`
+	Merge               string    `toml:"merge"`
+}
+
+type dumpConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Path    string `toml:"path"`
 }
 
 type udpConfig struct {
@@ -68,14 +87,101 @@ type udpConfig struct {
 }
 
 type tcpConfig struct {
-	Listen  string `toml:"listen"`
-	Enabled bool   `toml:"enabled"`
+	Name        string `toml:"name"` // [[tcp-listener]] only
+	Listen      string `toml:"listen"`
+	Enabled     bool   `toml:"enabled"`
+	Compression string `toml:"compression"`
+	TLSCert     string `toml:"tls-cert"`
+	TLSKey      string `toml:"tls-key"`
+	TLSCA       string `toml:"tls-ca"`
 }
 
 type pickleConfig struct {
 	Listen         string `toml:"listen"`
 	MaxMessageSize int    `toml:"max-message-size"`
 	Enabled        bool   `toml:"enabled"`
+	TLSCert        string `toml:"tls-cert"`
+	TLSKey         string `toml:"tls-key"`
+	TLSCA          string `toml:"tls-ca"`
+}
+
+type protobufConfig struct {
+	Listen         string `toml:"listen"`
+	MaxMessageSize int    `toml:"max-message-size"`
+	Enabled        bool   `toml:"enabled"`
+}
+
+type httpConfig struct {
+	Listen      string `toml:"listen"`
+	Enabled     bool   `toml:"enabled"`
+	MaxBodySize int    `toml:"max-body-size"`
+}
+
+type statsdConfig struct {
+	Listen        string    `toml:"listen"`
+	Enabled       bool      `toml:"enabled"`
+	Prefix        string    `toml:"prefix"`
+	FlushInterval *Duration `toml:"flush-interval"`
+	Percentiles   []float64 `toml:"percentiles"`
+}
+
+type influxConfig struct {
+	ListenTCP   string `toml:"listen-tcp"`
+	ListenUDP   string `toml:"listen-udp"`
+	ListenHTTP  string `toml:"listen-http"`
+	Enabled     bool   `toml:"enabled"`
+	Template    string `toml:"template"`
+	Tagged      bool   `toml:"tagged"`
+	MaxBodySize int    `toml:"max-body-size"`
+}
+
+type opentsdbConfig struct {
+	Listen   string   `toml:"listen"`
+	Enabled  bool     `toml:"enabled"`
+	TagOrder []string `toml:"tag-order"`
+	Tagged   bool     `toml:"tagged"`
+}
+
+type prometheusConfig struct {
+	Listen         string `toml:"listen"`
+	Enabled        bool   `toml:"enabled"`
+	MaxBodySize    int    `toml:"max-body-size"`
+	MaxDecodedSize int    `toml:"max-decoded-size"`
+	Tagged         bool   `toml:"tagged"`
+}
+
+type collectdConfig struct {
+	Listen  string `toml:"listen"`
+	Enabled bool   `toml:"enabled"`
+	Prefix  string `toml:"prefix"`
+	TypesDB string `toml:"types-db"`
+	Rate    bool   `toml:"rate"`
+}
+
+type rewriteConfig struct {
+	Enabled       bool   `toml:"enabled"`
+	RulesFilename string `toml:"rules-file"`
+	Rules         rewrite.Rules
+}
+
+type filterConfig struct {
+	Enabled       bool   `toml:"enabled"`
+	RulesFilename string `toml:"rules-file"`
+	DefaultAction string `toml:"default-action"`
+	Rules         *filter.Rules
+}
+
+type validationConfig struct {
+	Enabled   bool   `toml:"enabled"`
+	Action    string `toml:"action"`
+	MaxLength int    `toml:"max-length"`
+}
+
+type timestampsConfig struct {
+	Enabled   bool      `toml:"enabled"`
+	MaxFuture *Duration `toml:"max-future"`
+	MaxPast   *Duration `toml:"max-past"`
+	Action    string    `toml:"action"`
 }
 
 type carbonlinkConfig struct {
@@ -83,6 +189,18 @@ type carbonlinkConfig struct {
 	Enabled      bool      `toml:"enabled"`
 	ReadTimeout  *Duration `toml:"read-timeout"`
 	QueryTimeout *Duration `toml:"query-timeout"`
+	TLSCert      string    `toml:"tls-cert"`
+	TLSKey       string    `toml:"tls-key"`
+	TLSCA        string    `toml:"tls-ca"`
+}
+
+type carbonserverConfig struct {
+	Listen       string    `toml:"listen"`
+	Enabled      bool      `toml:"enabled"`
+	ReadTimeout  *Duration `toml:"read-timeout"`
+	WriteTimeout *Duration `toml:"write-timeout"`
+	QueryTimeout *Duration `toml:"query-timeout"`
+	MaxGlobs     int       `toml:"max-globs"`
 }
 
 type pprofConfig struct {
@@ -90,16 +208,36 @@ type pprofConfig struct {
 	Enabled bool   `toml:"enabled"`
 }
 
+type adminConfig struct {
+	Listen  string `toml:"listen"`
+	Enabled bool   `toml:"enabled"`
+}
+
 // Config ...
 type Config struct {
-	Common     commonConfig     `toml:"common"`
-	Whisper    whisperConfig    `toml:"whisper"`
-	Cache      cacheConfig      `toml:"cache"`
-	Udp        udpConfig        `toml:"udp"`
-	Tcp        tcpConfig        `toml:"tcp"`
-	Pickle     pickleConfig     `toml:"pickle"`
-	Carbonlink carbonlinkConfig `toml:"carbonlink"`
-	Pprof      pprofConfig      `toml:"pprof"`
+	Common       commonConfig       `toml:"common"`
+	Whisper      whisperConfig      `toml:"whisper"`
+	Cache        cacheConfig        `toml:"cache"`
+	Dump         dumpConfig         `toml:"dump"`
+	Rewrite      rewriteConfig      `toml:"rewrite"`
+	Filter       filterConfig       `toml:"filter"`
+	Validation   validationConfig   `toml:"validation"`
+	Timestamps   timestampsConfig   `toml:"timestamps"`
+	Udp          udpConfig          `toml:"udp"`
+	Tcp          tcpConfig          `toml:"tcp"`
+	TcpListeners []*tcpConfig       `toml:"tcp-listener"`
+	Pickle       pickleConfig       `toml:"pickle"`
+	Protobuf     protobufConfig     `toml:"protobuf"`
+	Http         httpConfig         `toml:"http"`
+	Statsd       statsdConfig       `toml:"statsd"`
+	Influx       influxConfig       `toml:"influxdb"`
+	OpenTSDB     opentsdbConfig     `toml:"opentsdb"`
+	Prometheus   prometheusConfig   `toml:"prometheus"`
+	Collectd     collectdConfig     `toml:"collectd"`
+	Carbonlink   carbonlinkConfig   `toml:"carbonlink"`
+	Carbonserver carbonserverConfig `toml:"carbonserver"`
+	Pprof        pprofConfig        `toml:"pprof"`
+	Admin        adminConfig        `toml:"admin"`
 }
 
 // NewConfig ...
@@ -120,13 +258,33 @@ func NewConfig() *Config {
 			SchemasFilename:     "/data/graphite/schemas",
 			AggregationFilename: "",
 			MaxUpdatesPerSecond: 0,
+			MaxCreatesPerSecond: 0,
 			Enabled:             true,
 			Workers:             1,
 			Sparse:              false,
+			MaxOpenFiles:        0,
+			OpenFilesIdle: &Duration{
+				Duration: time.Minute,
+			},
 		},
 		Cache: cacheConfig{
-			MaxSize:     1000000,
-			InputBuffer: 51200,
+			MaxSize:           1000000,
+			MaxMemoryBytes:    0,
+			InputBuffer:       51200,
+			Shards:            16,
+			WriteStrategy:     "max",
+			OverflowPolicy:    "drop-newest",
+			OverflowMaxPoints: 1000,
+			JournalDir:        "",
+			JournalSyncInterval: &Duration{
+				Duration: time.Second,
+			},
+			JournalSegmentSize: 67108864, // 64 Mb
+			Merge:              "none",
+		},
+		Dump: dumpConfig{
+			Enabled: false,
+			Path:    "/var/lib/graphite/dump/",
 		},
 		Udp: udpConfig{
 			Listen:        ":2003",
@@ -134,14 +292,79 @@ func NewConfig() *Config {
 			LogIncomplete: false,
 		},
 		Tcp: tcpConfig{
-			Listen:  ":2003",
-			Enabled: true,
+			Listen:      ":2003",
+			Enabled:     true,
+			Compression: "none",
 		},
 		Pickle: pickleConfig{
 			Listen:         ":2004",
 			Enabled:        true,
 			MaxMessageSize: 67108864, // 64 Mb
 		},
+		Protobuf: protobufConfig{
+			Listen:         ":2005",
+			Enabled:        false,
+			MaxMessageSize: 67108864, // 64 Mb
+		},
+		Http: httpConfig{
+			Listen:      ":2007",
+			Enabled:     false,
+			MaxBodySize: 67108864, // 64 Mb
+		},
+		Statsd: statsdConfig{
+			Listen:  ":8125",
+			Enabled: false,
+			Prefix:  "stats.",
+			FlushInterval: &Duration{
+				Duration: 10 * time.Second,
+			},
+			Percentiles: []float64{90},
+		},
+		Influx: influxConfig{
+			ListenHTTP:  ":8086",
+			Enabled:     false,
+			Template:    "{measurement}.{field}",
+			MaxBodySize: 67108864, // 64 Mb
+		},
+		OpenTSDB: opentsdbConfig{
+			Listen:   ":4242",
+			Enabled:  false,
+			TagOrder: []string{},
+		},
+		Prometheus: prometheusConfig{
+			Listen:         ":9201",
+			Enabled:        false,
+			MaxBodySize:    67108864,  // 64 Mb
+			MaxDecodedSize: 268435456, // 256 Mb
+		},
+		Collectd: collectdConfig{
+			Listen:  ":25826",
+			Enabled: false,
+		},
+		Rewrite: rewriteConfig{
+			Enabled:       false,
+			RulesFilename: "/etc/go-carbon/rewrite.conf",
+		},
+		Filter: filterConfig{
+			Enabled:       false,
+			RulesFilename: "/etc/go-carbon/filter.conf",
+			DefaultAction: "allow",
+		},
+		Validation: validationConfig{
+			Enabled:   false,
+			Action:    "reject",
+			MaxLength: 1024,
+		},
+		Timestamps: timestampsConfig{
+			Enabled: false,
+			MaxFuture: &Duration{
+				Duration: 10 * time.Minute,
+			},
+			MaxPast: &Duration{
+				Duration: 0,
+			},
+			Action: "reject",
+		},
 		Carbonlink: carbonlinkConfig{
 			Listen:  "127.0.0.1:7002",
 			Enabled: true,
@@ -152,10 +375,28 @@ func NewConfig() *Config {
 				Duration: 100 * time.Millisecond,
 			},
 		},
+		Carbonserver: carbonserverConfig{
+			Listen:  "127.0.0.1:8080",
+			Enabled: false,
+			ReadTimeout: &Duration{
+				Duration: 60 * time.Second,
+			},
+			WriteTimeout: &Duration{
+				Duration: 60 * time.Second,
+			},
+			QueryTimeout: &Duration{
+				Duration: 100 * time.Millisecond,
+			},
+			MaxGlobs: 100,
+		},
 		Pprof: pprofConfig{
 			Listen:  "localhost:7007",
 			Enabled: false,
 		},
+		Admin: adminConfig{
+			Listen:  "localhost:7008",
+			Enabled: false,
+		},
 	}
 
 	return cfg
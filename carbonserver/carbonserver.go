@@ -0,0 +1,553 @@
+package carbonserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hydrogen18/stalecucumber"
+	"github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/cache"
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/tags"
+)
+
+// CarbonserverListener serves render and find requests from graphite-web over HTTP
+type CarbonserverListener struct {
+	helper.Stoppable
+	whisperData    string
+	cacheQueryChan chan *cache.Query
+	out            chan *points.Points // for internal stats
+	graphPrefix    string
+	metricInterval time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	queryTimeout   time.Duration
+	maxGlobs       int
+	tcpListener    *net.TCPListener
+
+	renderRequests uint32
+	findRequests   uint32
+	cacheTimeouts  uint32
+	errors         uint32
+}
+
+// NewCarbonserverListener create new instance of CarbonserverListener
+func NewCarbonserverListener(cacheQueryChan chan *cache.Query, out chan *points.Points) *CarbonserverListener {
+	return &CarbonserverListener{
+		cacheQueryChan: cacheQueryChan,
+		out:            out,
+		metricInterval: time.Minute,
+		readTimeout:    60 * time.Second,
+		writeTimeout:   60 * time.Second,
+		queryTimeout:   100 * time.Millisecond,
+		maxGlobs:       100,
+	}
+}
+
+// SetWhisperData sets root directory of whisper files
+func (listener *CarbonserverListener) SetWhisperData(whisperData string) {
+	listener.whisperData = strings.TrimRight(whisperData, "/")
+}
+
+// SetGraphPrefix for internal metrics
+func (listener *CarbonserverListener) SetGraphPrefix(prefix string) {
+	listener.graphPrefix = prefix
+}
+
+// SetMetricInterval sets doChekpoint interval
+func (listener *CarbonserverListener) SetMetricInterval(interval time.Duration) {
+	listener.metricInterval = interval
+}
+
+// SetReadTimeout for http requests
+func (listener *CarbonserverListener) SetReadTimeout(timeout time.Duration) {
+	listener.readTimeout = timeout
+}
+
+// SetWriteTimeout for http responses
+func (listener *CarbonserverListener) SetWriteTimeout(timeout time.Duration) {
+	listener.writeTimeout = timeout
+}
+
+// SetQueryTimeout for queries to cache
+func (listener *CarbonserverListener) SetQueryTimeout(timeout time.Duration) {
+	listener.queryTimeout = timeout
+}
+
+// SetMaxGlobs limits count of brace expansions in one query
+func (listener *CarbonserverListener) SetMaxGlobs(maxGlobs int) {
+	listener.maxGlobs = maxGlobs
+}
+
+// Stat sends internal statistics to cache
+func (listener *CarbonserverListener) Stat(metric string, value float64) {
+	listener.out <- points.OnePoint(
+		fmt.Sprintf("%s%s", listener.graphPrefix, metric),
+		value,
+		time.Now().Unix(),
+	)
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (listener *CarbonserverListener) Addr() net.Addr {
+	if listener.tcpListener == nil {
+		return nil
+	}
+	return listener.tcpListener.Addr()
+}
+
+// findResult is one item of /metrics/find/ reply
+type findResult struct {
+	Path   string
+	IsLeaf bool
+}
+
+// expandBraces expands graphite {a,b} alternatives into list of plain globs
+func expandBraces(query string, maxGlobs int) ([]string, error) {
+	result := []string{query}
+
+	for {
+		var expanded []string
+		found := false
+
+		for _, q := range result {
+			open := strings.IndexByte(q, '{')
+			if open < 0 {
+				expanded = append(expanded, q)
+				continue
+			}
+			end := strings.IndexByte(q[open:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed brace in %#v", query)
+			}
+			end += open
+
+			found = true
+			for _, alt := range strings.Split(q[open+1:end], ",") {
+				expanded = append(expanded, q[:open]+alt+q[end+1:])
+			}
+		}
+
+		if maxGlobs > 0 && len(expanded) > maxGlobs {
+			return nil, fmt.Errorf("too many globs in %#v", query)
+		}
+
+		result = expanded
+		if !found {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// find returns metrics and directories matched by graphite glob query
+func (listener *CarbonserverListener) find(query string) ([]findResult, error) {
+	if strings.Contains(query, "/") || strings.Contains(query, "..") {
+		return nil, fmt.Errorf("bad query: %#v", query)
+	}
+
+	globs, err := expandBraces(strings.Replace(query, ".", "/", -1), listener.maxGlobs)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[findResult]bool)
+	var result []findResult
+
+	for _, glob := range globs {
+		pattern := filepath.Join(listener.whisperData, glob)
+
+		// directories
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dirs {
+			if st, err := os.Stat(d); err != nil || !st.IsDir() {
+				continue
+			}
+			item := findResult{Path: listener.metricName(d), IsLeaf: false}
+			if !seen[item] {
+				seen[item] = true
+				result = append(result, item)
+			}
+		}
+
+		// whisper files
+		files, err := filepath.Glob(pattern + ".wsp")
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			item := findResult{Path: listener.metricName(strings.TrimSuffix(f, ".wsp")), IsLeaf: true}
+			if !seen[item] {
+				seen[item] = true
+				result = append(result, item)
+			}
+		}
+	}
+
+	sort.Sort(findResultSorter(result))
+
+	return result, nil
+}
+
+type findResultSorter []findResult
+
+func (v findResultSorter) Len() int           { return len(v) }
+func (v findResultSorter) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v findResultSorter) Less(i, j int) bool { return v[i].Path < v[j].Path }
+
+func (listener *CarbonserverListener) metricName(path string) string {
+	rel := strings.TrimPrefix(path, listener.whisperData)
+	rel = strings.TrimPrefix(rel, "/")
+	return strings.Replace(rel, "/", ".", -1)
+}
+
+func (listener *CarbonserverListener) metricPath(metric string) string {
+	return tags.FilePath(listener.whisperData, metric)
+}
+
+// fetchResult is one series of /render/ reply
+type fetchResult struct {
+	Name   string
+	Start  int
+	End    int
+	Step   int
+	Values []float64 // NaN for absent points
+}
+
+// queryCache asks cache for points not written to disk yet
+func (listener *CarbonserverListener) queryCache(metric string) *cache.Query {
+	if listener.cacheQueryChan == nil {
+		return nil
+	}
+
+	query := cache.NewQuery(metric)
+
+	select {
+	case listener.cacheQueryChan <- query:
+	case <-time.After(listener.queryTimeout):
+		atomic.AddUint32(&listener.cacheTimeouts, 1)
+		return nil
+	}
+
+	select {
+	case <-query.Wait:
+		return query
+	case <-time.After(listener.queryTimeout):
+		atomic.AddUint32(&listener.cacheTimeouts, 1)
+		logrus.Infof("[carbonserver] Cache no reply (%s timeout)", listener.queryTimeout)
+		return nil
+	}
+}
+
+// mergeValues puts cached points on top of values fetched from whisper
+func mergeValues(result *fetchResult, data []*points.Point) {
+	if result.Step <= 0 {
+		return
+	}
+	for _, item := range data {
+		ts := int(item.Timestamp) - int(item.Timestamp)%result.Step
+		if ts < result.Start || ts >= result.End {
+			continue
+		}
+		index := (ts - result.Start) / result.Step
+		if index >= len(result.Values) {
+			continue
+		}
+		result.Values[index] = item.Value
+	}
+}
+
+func mergeCache(result *fetchResult, query *cache.Query) {
+	if query == nil {
+		return
+	}
+
+	// in-flight points are older than points in cache
+	for _, p := range query.InFlightData {
+		mergeValues(result, p.Data)
+	}
+
+	if query.CacheData != nil {
+		mergeValues(result, query.CacheData.Data)
+	}
+}
+
+func (listener *CarbonserverListener) fetch(metric string, from int, until int) (*fetchResult, error) {
+	path := listener.metricPath(metric)
+
+	w, err := whisper.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	series, err := w.Fetch(from, until)
+	if err != nil {
+		return nil, err
+	}
+	if series == nil {
+		return nil, fmt.Errorf("no data for %s in range %d-%d", metric, from, until)
+	}
+
+	result := &fetchResult{
+		Name:   metric,
+		Start:  series.FromTime(),
+		End:    series.UntilTime(),
+		Step:   series.Step(),
+		Values: series.Values(),
+	}
+
+	mergeCache(result, listener.queryCache(metric))
+
+	return result, nil
+}
+
+func parseTime(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(ts), nil
+}
+
+func (listener *CarbonserverListener) renderHandler(wr http.ResponseWriter, req *http.Request) {
+	atomic.AddUint32(&listener.renderRequests, 1)
+
+	req.ParseForm()
+
+	targets := req.Form["target"]
+	format := req.FormValue("format")
+
+	now := int(time.Now().Unix())
+
+	until, err := parseTime(req.FormValue("until"), now)
+	if err != nil {
+		listener.httpError(wr, fmt.Sprintf("bad until: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseTime(req.FormValue("from"), until-86400)
+	if err != nil {
+		listener.httpError(wr, fmt.Sprintf("bad from: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if len(targets) == 0 {
+		listener.httpError(wr, "empty target", http.StatusBadRequest)
+		return
+	}
+
+	var series []*fetchResult
+
+	for _, target := range targets {
+		var found []findResult
+
+		if tags.IsTagged(target) { // tagged series has no globs
+			name, err := tags.Normalize(target)
+			if err != nil {
+				listener.httpError(wr, err.Error(), http.StatusBadRequest)
+				return
+			}
+			found = []findResult{{Path: name, IsLeaf: true}}
+		} else {
+			found, err = listener.find(target)
+			if err != nil {
+				listener.httpError(wr, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		for _, item := range found {
+			if !item.IsLeaf {
+				continue
+			}
+			r, err := listener.fetch(item.Path, from, until)
+			if err != nil {
+				atomic.AddUint32(&listener.errors, 1)
+				logrus.Infof("[carbonserver] fetch %s failed: %s", item.Path, err.Error())
+				continue
+			}
+			series = append(series, r)
+		}
+	}
+
+	reply := make([]map[string]interface{}, 0, len(series))
+	for _, s := range series {
+		values := make([]interface{}, len(s.Values))
+		for i, v := range s.Values {
+			if math.IsNaN(v) {
+				values[i] = nil
+			} else {
+				values[i] = v
+			}
+		}
+		reply = append(reply, map[string]interface{}{
+			"name":   s.Name,
+			"start":  s.Start,
+			"end":    s.End,
+			"step":   s.Step,
+			"values": values,
+		})
+	}
+
+	listener.writeReply(wr, format, reply)
+}
+
+func (listener *CarbonserverListener) findHandler(wr http.ResponseWriter, req *http.Request) {
+	atomic.AddUint32(&listener.findRequests, 1)
+
+	req.ParseForm()
+
+	query := req.FormValue("query")
+	format := req.FormValue("format")
+
+	if query == "" {
+		listener.httpError(wr, "empty query", http.StatusBadRequest)
+		return
+	}
+
+	found, err := listener.find(query)
+	if err != nil {
+		listener.httpError(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reply := make([]map[string]interface{}, 0, len(found))
+	for _, item := range found {
+		reply = append(reply, map[string]interface{}{
+			"metric_path": item.Path,
+			"isLeaf":      item.IsLeaf,
+		})
+	}
+
+	listener.writeReply(wr, format, reply)
+}
+
+func (listener *CarbonserverListener) writeReply(wr http.ResponseWriter, format string, reply []map[string]interface{}) {
+	buf := new(bytes.Buffer)
+
+	switch format {
+	case "pickle":
+		list := make([]interface{}, len(reply))
+		for i, r := range reply {
+			list[i] = r
+		}
+		if _, err := stalecucumber.NewPickler(buf).Pickle(list); err != nil {
+			listener.httpError(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		wr.Header().Set("Content-Type", "application/pickle")
+	case "json", "":
+		if err := json.NewEncoder(buf).Encode(reply); err != nil {
+			listener.httpError(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		wr.Header().Set("Content-Type", "application/json")
+	default:
+		listener.httpError(wr, fmt.Sprintf("unknown format: %#v", format), http.StatusBadRequest)
+		return
+	}
+
+	wr.Write(buf.Bytes())
+}
+
+func (listener *CarbonserverListener) httpError(wr http.ResponseWriter, msg string, code int) {
+	atomic.AddUint32(&listener.errors, 1)
+	logrus.Infof("[carbonserver] %s", msg)
+	http.Error(wr, msg, code)
+}
+
+func (listener *CarbonserverListener) doCheckpoint() {
+	renderRequests := atomic.LoadUint32(&listener.renderRequests)
+	atomic.AddUint32(&listener.renderRequests, -renderRequests)
+	listener.Stat("renderRequests", float64(renderRequests))
+
+	findRequests := atomic.LoadUint32(&listener.findRequests)
+	atomic.AddUint32(&listener.findRequests, -findRequests)
+	listener.Stat("findRequests", float64(findRequests))
+
+	cacheTimeouts := atomic.LoadUint32(&listener.cacheTimeouts)
+	atomic.AddUint32(&listener.cacheTimeouts, -cacheTimeouts)
+	listener.Stat("cacheTimeouts", float64(cacheTimeouts))
+
+	errors := atomic.LoadUint32(&listener.errors)
+	atomic.AddUint32(&listener.errors, -errors)
+	listener.Stat("errors", float64(errors))
+
+	logrus.WithFields(logrus.Fields{
+		"renderRequests": int(renderRequests),
+		"findRequests":   int(findRequests),
+		"cacheTimeouts":  int(cacheTimeouts),
+		"errors":         int(errors),
+	}).Info("[carbonserver] doCheckpoint()")
+}
+
+// Listen bind port. Serve render and find requests
+func (listener *CarbonserverListener) Listen(addr *net.TCPAddr) error {
+	return listener.StartFunc(func() error {
+		tcpListener, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		listener.tcpListener = tcpListener
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/render/", listener.renderHandler)
+		mux.HandleFunc("/metrics/find/", listener.findHandler)
+
+		srv := &http.Server{
+			Handler:      mux,
+			ReadTimeout:  listener.readTimeout,
+			WriteTimeout: listener.writeTimeout,
+		}
+
+		listener.Go(func(exit chan bool) {
+			ticker := time.NewTicker(listener.metricInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					listener.doCheckpoint()
+				case <-exit:
+					tcpListener.Close()
+					return
+				}
+			}
+		})
+
+		listener.Go(func(exit chan bool) {
+			defer tcpListener.Close()
+
+			if err := srv.Serve(tcpListener); err != nil {
+				if !strings.Contains(err.Error(), "use of closed network connection") {
+					logrus.Errorf("[carbonserver] %s", err.Error())
+				}
+			}
+		})
+
+		return nil
+	})
+}
@@ -0,0 +1,125 @@
+package carbonserver
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lomik/go-carbon/cache"
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandBraces(t *testing.T) {
+	assert := assert.New(t)
+
+	globs, err := expandBraces("a.{b,c}.{d,e}", 0)
+	assert.NoError(err)
+	assert.Equal([]string{"a.b.d", "a.b.e", "a.c.d", "a.c.e"}, globs)
+
+	globs, err = expandBraces("a.b.*", 0)
+	assert.NoError(err)
+	assert.Equal([]string{"a.b.*"}, globs)
+
+	_, err = expandBraces("a.{b,c", 0)
+	assert.Error(err)
+
+	_, err = expandBraces("{a,b}.{c,d}", 3)
+	assert.Error(err)
+}
+
+func TestFind(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		for _, f := range []string{"a/b/c.wsp", "a/b/d.wsp", "a/e/f.wsp", "a/g.wsp"} {
+			path := filepath.Join(root, f)
+			assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+			assert.NoError(ioutil.WriteFile(path, []byte{}, 0644))
+		}
+
+		listener := NewCarbonserverListener(nil, nil)
+		listener.SetWhisperData(root)
+
+		found, err := listener.find("a.*")
+		assert.NoError(err)
+		assert.Equal([]findResult{
+			{"a.b", false},
+			{"a.e", false},
+			{"a.g", true},
+		}, found)
+
+		found, err = listener.find("a.{b,e}.*")
+		assert.NoError(err)
+		assert.Equal([]findResult{
+			{"a.b.c", true},
+			{"a.b.d", true},
+			{"a.e.f", true},
+		}, found)
+
+		_, err = listener.find("a/../../etc")
+		assert.Error(err)
+	})
+}
+
+func TestMergeCache(t *testing.T) {
+	assert := assert.New(t)
+
+	nan := math.NaN()
+
+	result := &fetchResult{
+		Start:  60,
+		End:    300,
+		Step:   60,
+		Values: []float64{1, nan, nan, nan},
+	}
+
+	query := cache.NewQuery("hello.world")
+	query.InFlightData = []*points.Points{
+		points.OnePoint("hello.world", 2, 125),
+		points.OnePoint("hello.world", 3, 180),
+	}
+	query.CacheData = points.OnePoint("hello.world", 4, 185).Add(5, 600)
+
+	mergeCache(result, query)
+
+	assert.Equal(1.0, result.Values[0])
+	assert.Equal(2.0, result.Values[1])
+	assert.Equal(4.0, result.Values[2]) // cache overrides in-flight
+	assert.True(math.IsNaN(result.Values[3]))
+}
+
+func TestFindHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		path := filepath.Join(root, "hello", "world.wsp")
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(ioutil.WriteFile(path, []byte{}, 0644))
+
+		listener := NewCarbonserverListener(nil, nil)
+		listener.SetWhisperData(root)
+
+		req, err := http.NewRequest("GET", "/metrics/find/?query=hello.*&format=json", nil)
+		assert.NoError(err)
+
+		rr := httptest.NewRecorder()
+		listener.findHandler(rr, req)
+
+		assert.Equal(http.StatusOK, rr.Code)
+		assert.Equal("[{\"isLeaf\":true,\"metric_path\":\"hello.world\"}]\n", rr.Body.String())
+
+		req, err = http.NewRequest("GET", "/metrics/find/?query=hello.*&format=xml", nil)
+		assert.NoError(err)
+
+		rr = httptest.NewRecorder()
+		listener.findHandler(rr, req)
+
+		assert.Equal(http.StatusBadRequest, rr.Code)
+	})
+}
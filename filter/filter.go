@@ -0,0 +1,358 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/alyu/configparser"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// Action of rule
+type Action int
+
+const (
+	// Allow passes metric to cache
+	Allow Action = iota
+	// Deny drops metric
+	Deny
+)
+
+// ParseAction parses "allow" or "deny"
+func ParseAction(action string) (Action, error) {
+	switch action {
+	case "allow":
+		return Allow, nil
+	case "deny":
+		return Deny, nil
+	}
+	return Allow, fmt.Errorf("unknown filter action %#v", action)
+}
+
+// Rule matches metric names by regular expression or graphite glob
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Action  Action
+	hits    uint64
+}
+
+// Rules is ordered list of rules. First matched rule is applied, Default if nothing matched
+type Rules struct {
+	List        []*Rule
+	Default     Action
+	defaultHits uint64
+}
+
+// GlobToRegexp converts graphite glob (*, ?, [a-z], {a,b}) to regular expression.
+// Wildcards do not match dots
+func GlobToRegexp(glob string) (*regexp.Regexp, error) {
+	var buf bytes.Buffer
+	buf.WriteString("^")
+
+	inBraces := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			buf.WriteString(`[^.]*`)
+		case '?':
+			buf.WriteString(`[^.]`)
+		case '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("unclosed [ in glob %#v", glob)
+			}
+			buf.WriteString(glob[i : i+j+1])
+			i += j
+		case '{':
+			if inBraces {
+				return nil, fmt.Errorf("nested { in glob %#v", glob)
+			}
+			inBraces = true
+			buf.WriteString("(?:")
+		case '}':
+			if !inBraces {
+				return nil, fmt.Errorf("unexpected } in glob %#v", glob)
+			}
+			inBraces = false
+			buf.WriteString(")")
+		case ',':
+			if inBraces {
+				buf.WriteString("|")
+			} else {
+				buf.WriteString(",")
+			}
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	if inBraces {
+		return nil, fmt.Errorf("unclosed { in glob %#v", glob)
+	}
+
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// ReadRules reads allow and deny rules file. Sections are checked in order of file:
+//
+//	[tmp]
+//	pattern = ^tmp\.
+//	action = deny
+//
+//	[servers]
+//	glob = servers.*.{cpu,memory}.*
+//	action = allow
+func ReadRules(file string) (*Rules, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := config.AllSections()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &Rules{}
+
+	for _, sec := range sections {
+		rule := &Rule{}
+		rule.Name =
+			strings.Trim(strings.SplitN(sec.String(), "\n", 2)[0], " []")
+		if rule.Name == "" || strings.HasPrefix(rule.Name, "#") {
+			continue
+		}
+
+		patternStr := sec.ValueOf("pattern")
+		globStr := sec.ValueOf("glob")
+
+		switch {
+		case patternStr != "" && globStr != "":
+			return nil, fmt.Errorf("[filter] Both pattern and glob for [%s]", rule.Name)
+		case patternStr != "":
+			rule.Pattern, err = regexp.Compile(patternStr)
+			if err != nil {
+				return nil, fmt.Errorf("[filter] Failed to parse pattern %q for [%s]: %s",
+					patternStr, rule.Name, err.Error())
+			}
+		case globStr != "":
+			rule.Pattern, err = GlobToRegexp(globStr)
+			if err != nil {
+				return nil, fmt.Errorf("[filter] Failed to parse glob %q for [%s]: %s",
+					globStr, rule.Name, err.Error())
+			}
+		default:
+			return nil, fmt.Errorf("[filter] Empty pattern and glob for [%s]", rule.Name)
+		}
+
+		rule.Action, err = ParseAction(sec.ValueOf("action"))
+		if err != nil {
+			return nil, fmt.Errorf("[filter] %s for [%s]", err.Error(), rule.Name)
+		}
+
+		rules.List = append(rules.List, rule)
+	}
+
+	return rules, nil
+}
+
+// Check returns true if metric is allowed
+func (rules *Rules) Check(metric string) bool {
+	for _, rule := range rules.List {
+		if rule.Pattern.MatchString(metric) {
+			if rule.Action == Deny {
+				atomic.AddUint64(&rule.hits, 1)
+				return false
+			}
+			return true
+		}
+	}
+
+	if rules.Default == Deny {
+		atomic.AddUint64(&rules.defaultHits, 1)
+		return false
+	}
+	return true
+}
+
+// Filter drops denied and invalid metrics from In() channel and sends others to out channel
+type Filter struct {
+	helper.Stoppable
+	rulesMutex     sync.RWMutex
+	in             chan *points.Points
+	out            chan *points.Points
+	rules          *Rules
+	validator      *points.Validator       // nil - names are not validated
+	window         *points.TimestampWindow // nil - timestamps are not checked
+	graphPrefix    string
+	metricInterval time.Duration
+}
+
+// New create new instance of Filter
+func New(out chan *points.Points) *Filter {
+	return &Filter{
+		in:             make(chan *points.Points, 1024),
+		out:            out,
+		rules:          &Rules{},
+		metricInterval: time.Minute,
+	}
+}
+
+// In returns input channel
+func (f *Filter) In() chan *points.Points {
+	return f.in
+}
+
+// SetRules replaces rules. Can be called after Start()
+func (f *Filter) SetRules(rules *Rules) {
+	f.rulesMutex.Lock()
+	f.rules = rules
+	f.rulesMutex.Unlock()
+}
+
+// SetValidator enables validation of metric names before rules are checked. Call before Start()
+func (f *Filter) SetValidator(validator *points.Validator) {
+	f.validator = validator
+}
+
+// SetTimestampWindow enables check of timestamps. Call before Start()
+func (f *Filter) SetTimestampWindow(window *points.TimestampWindow) {
+	f.window = window
+}
+
+// SetGraphPrefix for internal filter metrics
+func (f *Filter) SetGraphPrefix(prefix string) {
+	f.graphPrefix = prefix
+}
+
+// SetMetricInterval sets doChekpoint interval
+func (f *Filter) SetMetricInterval(interval time.Duration) {
+	f.metricInterval = interval
+}
+
+// Stat sends internal statistics to cache
+func (f *Filter) Stat(metric string, value float64) {
+	f.out <- points.OnePoint(
+		fmt.Sprintf("%s%s", f.graphPrefix, metric),
+		value,
+		time.Now().Unix(),
+	)
+}
+
+func (f *Filter) doCheckpoint() {
+	f.rulesMutex.RLock()
+	rules := f.rules
+	f.rulesMutex.RUnlock()
+
+	var total uint64
+
+	for _, rule := range rules.List {
+		if rule.Action != Deny {
+			continue
+		}
+		hits := atomic.LoadUint64(&rule.hits)
+		atomic.AddUint64(&rule.hits, -hits)
+		total += hits
+		f.Stat(fmt.Sprintf("rejected.%s", strings.Replace(rule.Name, ".", "_", -1)), float64(hits))
+	}
+
+	if rules.Default == Deny {
+		hits := atomic.LoadUint64(&rules.defaultHits)
+		atomic.AddUint64(&rules.defaultHits, -hits)
+		total += hits
+		f.Stat("rejected.default", float64(hits))
+	}
+
+	f.Stat("rejected", float64(total))
+
+	var invalid, normalized uint64
+
+	if f.validator != nil {
+		for reason, cnt := range f.validator.Rejected() {
+			invalid += cnt
+			f.Stat(fmt.Sprintf("invalid.%s", points.ReasonName(reason)), float64(cnt))
+		}
+		for reason, cnt := range f.validator.Normalized() {
+			normalized += cnt
+			f.Stat(fmt.Sprintf("normalized.%s", points.ReasonName(reason)), float64(cnt))
+		}
+	}
+
+	var future, past uint64
+
+	if f.window != nil {
+		future = f.window.Future()
+		f.Stat(fmt.Sprintf("timestamp.future.%s", f.window.ActionName()), float64(future))
+
+		past = f.window.Past()
+		f.Stat(fmt.Sprintf("timestamp.past.%s", f.window.ActionName()), float64(past))
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"rules":      len(rules.List),
+		"rejected":   int(total),
+		"invalid":    int(invalid),
+		"normalized": int(normalized),
+		"future":     int(future),
+		"past":       int(past),
+	}).Info("[filter] doCheckpoint()")
+}
+
+func (f *Filter) check(p *points.Points) {
+	if f.validator != nil {
+		metric, ok := f.validator.Validate(p.Metric)
+		if !ok {
+			return
+		}
+		p.Metric = metric
+	}
+
+	f.rulesMutex.RLock()
+	rules := f.rules
+	f.rulesMutex.RUnlock()
+
+	if !rules.Check(p.Metric) {
+		return
+	}
+
+	if f.window != nil && !f.window.Check(p, time.Now().Unix()) {
+		return
+	}
+
+	f.out <- p
+}
+
+// Start starts workers
+func (f *Filter) Start() error {
+	return f.StartFunc(func() error {
+		helper.NewShuffler(f.in, runtime.GOMAXPROCS(0), f.check).Start(&f.Stoppable)
+
+		f.Go(func(exit chan bool) {
+			ticker := time.NewTicker(f.metricInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					f.doCheckpoint()
+				case <-exit:
+					return
+				}
+			}
+		})
+
+		return nil
+	})
+}
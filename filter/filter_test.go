@@ -0,0 +1,203 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseRules(t *testing.T, content string) (rules *Rules, err error) {
+	qa.File(t, "filter.conf", content, func(filename string) {
+		rules, err = ReadRules(filename)
+	})
+	return
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		glob    string
+		match   []string
+		noMatch []string
+	}{
+		{"servers.*.cpu", []string{"servers.web01.cpu", "servers..cpu"}, []string{"servers.a.b.cpu", "xservers.a.cpu"}},
+		{"a.b?", []string{"a.bc"}, []string{"a.b", "a.b."}},
+		{"a.{cpu,memory}.*", []string{"a.cpu.x", "a.memory.y"}, []string{"a.disk.x"}},
+		{"a.[0-9]", []string{"a.1"}, []string{"a.x"}},
+		{"a+b.c", []string{"a+b.c"}, []string{"aab.c", "a+bxc"}},
+	}
+
+	for _, c := range table {
+		re, err := GlobToRegexp(c.glob)
+		if !assert.NoError(err, c.glob) {
+			continue
+		}
+		for _, m := range c.match {
+			assert.True(re.MatchString(m), "%s %s", c.glob, m)
+		}
+		for _, m := range c.noMatch {
+			assert.False(re.MatchString(m), "%s %s", c.glob, m)
+		}
+	}
+
+	for _, glob := range []string{"a.{b", "a.b}", "a.{b,{c}}", "a.[b"} {
+		_, err := GlobToRegexp(glob)
+		assert.Error(err, glob)
+	}
+}
+
+func TestRules(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, err := parseRules(t, `
+[tmp]
+pattern = ^tmp\.
+action = deny
+
+[servers]
+glob = servers.*.{cpu,memory}
+action = allow
+
+[other_servers]
+glob = servers.*.*
+action = deny
+`)
+	assert.NoError(err)
+	assert.Len(rules.List, 3)
+
+	assert.False(rules.Check("tmp.metric"))
+	assert.True(rules.Check("servers.web01.cpu"))
+	assert.False(rules.Check("servers.web01.disk"))
+	assert.True(rules.Check("other.metric"))
+
+	rules.Default = Deny
+	assert.False(rules.Check("other.metric"))
+
+	assert.Equal(uint64(1), rules.List[0].hits)
+	assert.Equal(uint64(0), rules.List[1].hits)
+	assert.Equal(uint64(1), rules.List[2].hits)
+	assert.Equal(uint64(1), rules.defaultHits)
+
+	bad := []string{
+		"[a]\naction = deny\n",
+		"[a]\npattern = a\nglob = a\naction = deny\n",
+		"[a]\npattern = (\naction = deny\n",
+		"[a]\nglob = {\naction = deny\n",
+		"[a]\npattern = a\naction = drop\n",
+		"[a]\npattern = a\n",
+	}
+
+	for _, content := range bad {
+		_, err := parseRules(t, content)
+		assert.Error(err, content)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, err := parseRules(t, "[tmp]\nglob = tmp.*\naction = deny\n")
+	assert.NoError(err)
+
+	out := make(chan *points.Points, 128)
+	f := New(out)
+	f.SetGraphPrefix("carbon.agents.localhost.filter.")
+	f.SetMetricInterval(50 * time.Millisecond)
+	f.Start()
+	defer f.Stop()
+
+	// all allowed before rules set
+	f.In() <- points.OnePoint("tmp.metric", 1, 1422698155)
+	select {
+	case p := <-out:
+		assert.Equal("tmp.metric", p.Metric)
+	case <-time.After(time.Second):
+		t.Fatal("metric not received")
+	}
+
+	f.SetRules(rules)
+
+	f.In() <- points.OnePoint("tmp.metric", 1, 1422698155)
+	f.In() <- points.OnePoint("good.metric", 1, 1422698155)
+
+	select {
+	case p := <-out:
+		assert.Equal("good.metric", p.Metric)
+	case <-time.After(time.Second):
+		t.Fatal("metric not received")
+	}
+
+	stats := make(map[string]float64)
+	timeout := time.After(time.Second)
+	for stats["carbon.agents.localhost.filter.rejected"] < 1 {
+		select {
+		case p := <-out:
+			stats[p.Metric] += p.Data[0].Value
+		case <-timeout:
+			t.Fatalf("stats not received: %#v", stats)
+		}
+	}
+
+	assert.Equal(map[string]float64{
+		"carbon.agents.localhost.filter.rejected.tmp": 1,
+		"carbon.agents.localhost.filter.rejected":     1,
+	}, stats)
+}
+
+func TestFilterValidator(t *testing.T) {
+	assert := assert.New(t)
+
+	out := make(chan *points.Points, 128)
+	f := New(out)
+	f.SetValidator(points.NewValidator())
+	f.Start()
+	defer f.Stop()
+
+	f.In() <- points.OnePoint("a/../../b", 1, 1422698155)
+	f.In() <- points.OnePoint("good.metric", 1, 1422698155)
+
+	select {
+	case p := <-out:
+		assert.Equal("good.metric", p.Metric)
+	case <-time.After(time.Second):
+		t.Fatal("metric not received")
+	}
+
+	select {
+	case p := <-out:
+		t.Fatalf("unexpected metric %#v", p)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestFilterTimestampWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	out := make(chan *points.Points, 128)
+	f := New(out)
+	f.SetTimestampWindow(points.NewTimestampWindow(time.Hour, 0, points.WindowReject))
+	f.Start()
+	defer f.Stop()
+
+	now := time.Now().Unix()
+
+	f.In() <- points.OnePoint("future.metric", 1, now+7200)
+	f.In() <- points.OnePoint("good.metric", 1, now)
+
+	select {
+	case p := <-out:
+		assert.Equal("good.metric", p.Metric)
+	case <-time.After(time.Second):
+		t.Fatal("metric not received")
+	}
+
+	select {
+	case p := <-out:
+		t.Fatalf("unexpected metric %#v", p)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,79 @@
+package helper
+
+import (
+	"hash/crc32"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+// Shuffler passes points from input channel to pool of workers. Points of each metric are
+// always passed to the same worker, so their order is kept
+type Shuffler struct {
+	in      chan *points.Points
+	workers int
+	handle  func(p *points.Points)
+}
+
+// NewShuffler creates Shuffler with workers calling handle
+func NewShuffler(in chan *points.Points, workers int, handle func(p *points.Points)) *Shuffler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Shuffler{
+		in:      in,
+		workers: workers,
+		handle:  handle,
+	}
+}
+
+// Start runs shuffler and workers in goroutines of started owner. Rest of input is handled on
+// owner stop, because senders are stopped first
+func (s *Shuffler) Start(owner *Stoppable) {
+	var channels [](chan *points.Points)
+	for i := 0; i < s.workers; i++ {
+		ch := make(chan *points.Points, 32)
+		channels = append(channels, ch)
+		owner.Go(func(exit chan bool) {
+			s.worker(ch)
+		})
+	}
+
+	owner.Go(func(exit chan bool) {
+		s.shuffler(channels, exit)
+	})
+}
+
+func (s *Shuffler) shuffler(out [](chan *points.Points), exit chan bool) {
+	workers := uint32(len(out))
+	send := func(p *points.Points) {
+		out[crc32.ChecksumIEEE([]byte(p.Metric))%workers] <- p
+	}
+
+	defer func() {
+		for _, ch := range out {
+			close(ch)
+		}
+	}()
+
+	for {
+		select {
+		case <-exit:
+			for {
+				select {
+				case p := <-s.in:
+					send(p)
+				default:
+					return
+				}
+			}
+		case p := <-s.in:
+			send(p)
+		}
+	}
+}
+
+func (s *Shuffler) worker(in chan *points.Points) {
+	for p := range in {
+		s.handle(p)
+	}
+}
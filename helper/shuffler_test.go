@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShufflerOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *points.Points, 1024)
+	out := make(chan *points.Points, 1024)
+
+	owner := &Stoppable{}
+	owner.StartFunc(func() error {
+		NewShuffler(in, 4, func(p *points.Points) { out <- p }).Start(owner)
+		return nil
+	})
+
+	for i := 0; i < 1000; i++ {
+		in <- points.OnePoint(fmt.Sprintf("metric%d", i%3), float64(i), 1422698155)
+	}
+	owner.Stop() // rest of input is handled on stop
+
+	last := make(map[string]float64)
+	for len(out) > 0 {
+		p := <-out
+		if v, exists := last[p.Metric]; exists {
+			assert.True(p.Data[0].Value > v, "points of %s are reordered", p.Metric)
+		}
+		last[p.Metric] = p.Data[0].Value
+	}
+	assert.Equal(map[string]float64{"metric0": 999, "metric1": 997, "metric2": 998}, last)
+}
@@ -0,0 +1,85 @@
+package helper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+)
+
+// TLSConfig holds server certificate and optional CA of client certificates.
+// Certificates can be reloaded from files without restart of listener
+type TLSConfig struct {
+	sync.RWMutex
+	config *tls.Config
+}
+
+// NewTLSConfig loads certificate and key. Client certificates are required and
+// verified if caFile is not empty (mutual TLS)
+func NewTLSConfig(certFile, keyFile, caFile string) (*TLSConfig, error) {
+	t := &TLSConfig{}
+
+	if err := t.Reload(certFile, keyFile, caFile); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Reload reads certificates from files of current config. New config is used for new connections only
+func (t *TLSConfig) Reload(certFile, keyFile, caFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates found in %s", caFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	t.Lock()
+	t.config = config
+	t.Unlock()
+
+	return nil
+}
+
+// ReloadTLS reloads config of running listener. TLS can not be enabled or disabled without restart
+func ReloadTLS(t *TLSConfig, certFile, keyFile, caFile string) error {
+	if t == nil {
+		if certFile != "" {
+			return fmt.Errorf("TLS can not be enabled without restart")
+		}
+		return nil
+	}
+	if certFile == "" {
+		return fmt.Errorf("TLS can not be disabled without restart")
+	}
+	return t.Reload(certFile, keyFile, caFile)
+}
+
+// Server wraps accepted connection
+func (t *TLSConfig) Server(conn net.Conn) net.Conn {
+	t.RLock()
+	config := t.config
+	t.RUnlock()
+
+	return tls.Server(conn, config)
+}
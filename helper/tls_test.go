@@ -0,0 +1,127 @@
+package helper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert creates self-signed certificate and key files
+func writeCert(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return
+}
+
+func TestTLSConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	qa.Root(t, func(root string) {
+		serverCert, serverKey := writeCert(t, root, "server")
+		clientCert, clientKey := writeCert(t, root, "client")
+
+		_, err := NewTLSConfig(serverCert, serverKey, filepath.Join(root, "notexists"))
+		assert.Error(err)
+
+		config, err := NewTLSConfig(serverCert, serverKey, clientCert)
+		assert.NoError(err)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(err)
+		defer listener.Close()
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					conn = config.Server(conn)
+					defer conn.Close()
+					conn.Write([]byte("ok"))
+				}()
+			}
+		}()
+
+		ca, _ := ioutil.ReadFile(serverCert)
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+
+		dial := func(certificates []tls.Certificate) ([]byte, error) {
+			conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+				RootCAs:      pool,
+				Certificates: certificates,
+			})
+			if err != nil {
+				return nil, err
+			}
+			defer conn.Close()
+			return ioutil.ReadAll(conn)
+		}
+
+		// client certificate required
+		_, err = dial(nil)
+		assert.Error(err)
+
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		assert.NoError(err)
+
+		body, err := dial([]tls.Certificate{cert})
+		assert.NoError(err)
+		assert.Equal("ok", string(body))
+
+		// new files are used on reload
+		newCert, newKey := writeCert(t, root, "new")
+		assert.NoError(config.Reload(newCert, newKey, ""))
+		assert.Error(config.Reload(serverCert, serverKey, filepath.Join(root, "notexists")))
+
+		assert.Error(ReloadTLS(nil, serverCert, serverKey, ""))
+		assert.Error(ReloadTLS(config, "", "", ""))
+		assert.NoError(ReloadTLS(nil, "", "", ""))
+	})
+}
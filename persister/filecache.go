@@ -0,0 +1,110 @@
+package persister
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/lomik/go-whisper"
+)
+
+type openFile struct {
+	path    string
+	w       *whisper.Whisper
+	lastUse time.Time
+}
+
+// fileCache is LRU of open whisper files. Owned by one persister worker, not thread safe
+type fileCache struct {
+	maxSize     int
+	idleTimeout time.Duration
+	lru         *list.List // front - recently used
+	files       map[string]*list.Element
+}
+
+func newFileCache(maxSize int, idleTimeout time.Duration) *fileCache {
+	return &fileCache{
+		maxSize:     maxSize,
+		idleTimeout: idleTimeout,
+		lru:         list.New(),
+		files:       make(map[string]*list.Element),
+	}
+}
+
+// get returns open file or nil
+func (c *fileCache) get(path string) *whisper.Whisper {
+	e, exists := c.files[path]
+	if !exists {
+		return nil
+	}
+
+	f := e.Value.(*openFile)
+	f.lastUse = time.Now()
+	c.lru.MoveToFront(e)
+
+	return f.w
+}
+
+// put adds open file to cache. Least recently used file is closed if cache is full.
+// File is closed immediately if cache is disabled
+func (c *fileCache) put(path string, w *whisper.Whisper) {
+	if c.maxSize <= 0 {
+		w.Close()
+		return
+	}
+
+	if _, exists := c.files[path]; exists {
+		c.remove(path)
+	}
+
+	for c.lru.Len() >= c.maxSize {
+		c.closeElement(c.lru.Back())
+	}
+
+	c.files[path] = c.lru.PushFront(&openFile{
+		path:    path,
+		w:       w,
+		lastUse: time.Now(),
+	})
+}
+
+// remove closes and removes file from cache. Called after write errors
+func (c *fileCache) remove(path string) {
+	if e, exists := c.files[path]; exists {
+		c.closeElement(e)
+	}
+}
+
+func (c *fileCache) closeElement(e *list.Element) {
+	f := e.Value.(*openFile)
+	f.w.Close()
+	c.lru.Remove(e)
+	delete(c.files, f.path)
+}
+
+// expire closes files not used for idleTimeout
+func (c *fileCache) expire() {
+	if c.idleTimeout <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(-c.idleTimeout)
+
+	for e := c.lru.Back(); e != nil; e = c.lru.Back() {
+		if e.Value.(*openFile).lastUse.After(deadline) {
+			break
+		}
+		c.closeElement(e)
+	}
+}
+
+// close closes all open files
+func (c *fileCache) close() {
+	for e := c.lru.Back(); e != nil; e = c.lru.Back() {
+		c.closeElement(e)
+	}
+}
+
+// size returns count of open files
+func (c *fileCache) size() int {
+	return c.lru.Len()
+}
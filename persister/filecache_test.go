@@ -0,0 +1,55 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-whisper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCache(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newFileCache(2, time.Minute)
+
+	w1, w2, w3 := &whisper.Whisper{}, &whisper.Whisper{}, &whisper.Whisper{}
+
+	c.put("1.wsp", w1)
+	c.put("2.wsp", w2)
+	assert.Equal(2, c.size())
+
+	assert.True(c.get("1.wsp") == w1) // 2.wsp is least recently used now
+
+	c.put("3.wsp", w3)
+	assert.Equal(2, c.size())
+	assert.Nil(c.get("2.wsp"))
+	assert.True(c.get("1.wsp") == w1)
+	assert.True(c.get("3.wsp") == w3)
+
+	c.close()
+	assert.Equal(0, c.size())
+	assert.Nil(c.get("1.wsp"))
+}
+
+func TestFileCacheExpire(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newFileCache(10, 50*time.Millisecond)
+
+	c.put("1.wsp", &whisper.Whisper{})
+	time.Sleep(60 * time.Millisecond)
+	c.put("2.wsp", &whisper.Whisper{})
+
+	c.expire()
+
+	assert.Equal(1, c.size())
+	assert.Nil(c.get("1.wsp"))
+	assert.NotNil(c.get("2.wsp"))
+}
+
+func TestFileCacheDisabled(t *testing.T) {
+	c := newFileCache(0, time.Minute)
+	c.put("1.wsp", &whisper.Whisper{})
+	assert.Equal(t, 0, c.size())
+}
@@ -0,0 +1,60 @@
+package persister
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is token bucket shared by persister workers
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64 // tokens per second, also bucket size
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(ratePerSec int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(ratePerSec),
+		tokens: float64(ratePerSec),
+		last:   time.Now(),
+	}
+}
+
+// allow takes one token. Returns false if bucket is empty
+func (r *rateLimiter) allow() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// delay returns time until next token
+func (r *rateLimiter) delay() time.Duration {
+	r.Lock()
+	defer r.Unlock()
+
+	tokens := r.tokens + time.Now().Sub(r.last).Seconds()*r.rate
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / r.rate * float64(time.Second))
+}
+
+// wait blocks until token is taken
+func (r *rateLimiter) wait() {
+	for !r.allow() {
+		time.Sleep(r.delay())
+	}
+}
@@ -0,0 +1,25 @@
+package persister
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newRateLimiter(10)
+
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if r.allow() {
+			allowed++
+		}
+	}
+	assert.Equal(10, allowed)
+
+	time.Sleep(150 * time.Millisecond)
+	assert.True(r.allow())
+}
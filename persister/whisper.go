@@ -1,6 +1,7 @@
 package persister
 
 import (
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"os"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/lomik/go-carbon/helper"
 	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/tags"
 )
 
 // Whisper write data to *.wsp files
@@ -31,8 +33,13 @@ type Whisper struct {
 	rootPath            string
 	graphPrefix         string
 	created             uint32 // counter
+	createDeferred      uint32 // counter
 	sparse              bool
 	maxUpdatesPerSecond int
+	maxOpenFiles        int          // per worker
+	createLimiter       *rateLimiter // nil - unlimited
+	requeue             func(values *points.Points, notBefore time.Time)
+	openFilesIdle       time.Duration // close open files after this idle time
 	mockStore           func(p *Whisper, values *points.Points)
 }
 
@@ -47,6 +54,7 @@ func NewWhisper(rootPath string, schemas WhisperSchemas, aggregation *WhisperAgg
 		workersCount:        1,
 		rootPath:            rootPath,
 		maxUpdatesPerSecond: 0,
+		openFilesIdle:       time.Minute,
 	}
 }
 
@@ -65,6 +73,30 @@ func (p *Whisper) GetMaxUpdatesPerSecond() int {
 	return p.maxUpdatesPerSecond
 }
 
+// SetMaxCreatesPerSecond limits creation of new whisper files. 0 - no limit
+func (p *Whisper) SetMaxCreatesPerSecond(maxCreatesPerSecond int) {
+	if maxCreatesPerSecond > 0 {
+		p.createLimiter = newRateLimiter(maxCreatesPerSecond)
+	} else {
+		p.createLimiter = nil
+	}
+}
+
+// SetRequeue sets function returning points of metrics with deferred file creation to cache. Usually Cache.Requeue
+func (p *Whisper) SetRequeue(requeue func(values *points.Points, notBefore time.Time)) {
+	p.requeue = requeue
+}
+
+// SetMaxOpenFiles sets size of open files cache of each worker. 0 - close file after each update
+func (p *Whisper) SetMaxOpenFiles(count int) {
+	p.maxOpenFiles = count
+}
+
+// SetOpenFilesIdleTimeout sets time after which not used open file is closed
+func (p *Whisper) SetOpenFilesIdleTimeout(timeout time.Duration) {
+	p.openFilesIdle = timeout
+}
+
 // SetWorkers count
 func (p *Whisper) SetWorkers(count int) {
 	p.workersCount = count
@@ -89,31 +121,92 @@ func (p *Whisper) Stat(metric string, value float64) {
 	)
 }
 
-func store(p *Whisper, values *points.Points) {
-	path := filepath.Join(p.rootPath, strings.Replace(values.Metric, ".", "/", -1)+".wsp")
+func store(p *Whisper, files *fileCache, values *points.Points) {
+	path := tags.FilePath(p.rootPath, values.Metric)
 
 	if p.confirm != nil {
 		defer func() { p.confirm <- values }()
 	}
 
+	// names like "a./../../b" are rejected by [validation] of receivers. Never write outside of data dir
+	if !strings.HasPrefix(path, filepath.Clean(p.rootPath)+string(filepath.Separator)) {
+		logrus.Errorf("[persister] Path %s of metric %#v is outside of data dir", path, values.Metric)
+		return
+	}
+
+	var err error
+
+	w := files.get(path)
+	cached := w != nil
+	if !cached {
+		w, err = open(p, values, path)
+		if err == errCreateDeferred {
+			return
+		}
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+	}
+
+	points := make([]*whisper.TimeSeriesPoint, len(values.Data))
+	for i, r := range values.Data {
+		points[i] = &whisper.TimeSeriesPoint{Time: int(r.Timestamp), Value: r.Value}
+	}
+
+	atomic.AddUint32(&p.commitedPoints, uint32(len(values.Data)))
+	atomic.AddUint32(&p.updateOperations, 1)
+
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("[persister] UpdateMany %s recovered: %s", path, r)
+			if cached {
+				files.remove(path)
+			} else {
+				w.Close()
+			}
+		}
+	}()
+	w.UpdateMany(points)
+
+	if !cached {
+		files.put(path, w) // closed if open files cache is disabled
+	}
+}
+
+var errCreateDeferred = errors.New("whisper file creation deferred")
+
+// open opens whisper file or creates new one by schema and aggregation.
+// Creation over max-creates-per-second limit is deferred: points are requeued to cache
+// and retried later. Without requeue function worker waits for the limiter
+func open(p *Whisper, values *points.Points, path string) (*whisper.Whisper, error) {
+	metric := values.Metric
+
 	w, err := whisper.Open(path)
 	if err != nil {
 		// create new whisper if file not exists
 		if !os.IsNotExist(err) {
-			logrus.Errorf("[persister] Failed to open whisper file %s: %s", path, err.Error())
-			return
+			return nil, fmt.Errorf("[persister] Failed to open whisper file %s: %s", path, err.Error())
+		}
+
+		if p.createLimiter != nil && !p.createLimiter.allow() {
+			atomic.AddUint32(&p.createDeferred, 1)
+			if p.requeue != nil {
+				// original is confirmed after return
+				p.requeue(values, time.Now().Add(p.createLimiter.delay()))
+				return nil, errCreateDeferred
+			}
+			p.createLimiter.wait()
 		}
 
-		schema, ok := p.schemas.Match(values.Metric)
+		schema, ok := p.schemas.Match(metric)
 		if !ok {
-			logrus.Errorf("[persister] No storage schema defined for %s", values.Metric)
-			return
+			return nil, fmt.Errorf("[persister] No storage schema defined for %s", metric)
 		}
 
-		aggr := p.aggregation.match(values.Metric)
+		aggr := p.aggregation.match(metric)
 		if aggr == nil {
-			logrus.Errorf("[persister] No storage aggregation defined for %s", values.Metric)
-			return
+			return nil, fmt.Errorf("[persister] No storage aggregation defined for %s", metric)
 		}
 
 		logrus.WithFields(logrus.Fields{
@@ -125,43 +218,40 @@ func store(p *Whisper, values *points.Points) {
 		}).Debugf("[persister] Creating %s", path)
 
 		if err = os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm); err != nil {
-			logrus.Error(err)
-			return
+			return nil, err
 		}
 
 		w, err = whisper.CreateWithOptions(path, schema.Retentions, aggr.aggregationMethod, float32(aggr.xFilesFactor), &whisper.Options{
 			Sparse: p.sparse,
 		})
 		if err != nil {
-			logrus.Errorf("[persister] Failed to create new whisper file %s: %s", path, err.Error())
-			return
+			return nil, fmt.Errorf("[persister] Failed to create new whisper file %s: %s", path, err.Error())
 		}
 
 		atomic.AddUint32(&p.created, 1)
 	}
 
-	points := make([]*whisper.TimeSeriesPoint, len(values.Data))
-	for i, r := range values.Data {
-		points[i] = &whisper.TimeSeriesPoint{Time: int(r.Timestamp), Value: r.Value}
-	}
-
-	atomic.AddUint32(&p.commitedPoints, uint32(len(values.Data)))
-	atomic.AddUint32(&p.updateOperations, 1)
-
-	defer w.Close()
-
-	defer func() {
-		if r := recover(); r != nil {
-			logrus.Errorf("[persister] UpdateMany %s recovered: %s", path, r)
-		}
-	}()
-	w.UpdateMany(points)
+	return w, nil
 }
 
 func (p *Whisper) worker(in chan *points.Points, exit chan bool) {
-	storeFunc := store
+	files := newFileCache(p.maxOpenFiles, p.openFilesIdle)
+	defer files.close()
+
+	storeFunc := func(values *points.Points) {
+		store(p, files, values)
+	}
 	if p.mockStore != nil {
-		storeFunc = p.mockStore
+		storeFunc = func(values *points.Points) {
+			p.mockStore(p, values)
+		}
+	}
+
+	var expire <-chan time.Time
+	if p.maxOpenFiles > 0 && p.openFilesIdle > 0 {
+		ticker := time.NewTicker(p.openFilesIdle)
+		defer ticker.Stop()
+		expire = ticker.C
 	}
 
 LOOP:
@@ -169,11 +259,13 @@ LOOP:
 		select {
 		case <-exit:
 			break LOOP
+		case <-expire:
+			files.expire()
 		case values, ok := <-in:
 			if !ok {
 				break LOOP
 			}
-			storeFunc(p, values)
+			storeFunc(values)
 		}
 	}
 }
@@ -210,10 +302,14 @@ func (p *Whisper) doCheckpoint() {
 	created := atomic.LoadUint32(&p.created)
 	atomic.AddUint32(&p.created, -created)
 
+	createDeferred := atomic.LoadUint32(&p.createDeferred)
+	atomic.AddUint32(&p.createDeferred, -createDeferred)
+
 	logrus.WithFields(logrus.Fields{
 		"updateOperations": int(updateOperations),
 		"commitedPoints":   int(commitedPoints),
 		"created":          int(created),
+		"createDeferred":   int(createDeferred),
 	}).Info("[persister] doCheckpoint()")
 
 	p.Stat("updateOperations", float64(updateOperations))
@@ -225,6 +321,7 @@ func (p *Whisper) doCheckpoint() {
 	}
 
 	p.Stat("created", float64(created))
+	p.Stat("createDeferred", float64(createDeferred))
 
 }
 
@@ -12,11 +12,14 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/alyu/configparser"
 	"github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/tags"
 )
 
 type whisperAggregationItem struct {
 	name                 string
 	pattern              *regexp.Regexp
+	tags                 tags.Matcher
 	xFilesFactor         float64
 	aggregationMethodStr string
 	aggregationMethod    whisper.AggregationMethod
@@ -73,6 +76,13 @@ func ReadWhisperAggregation(file string) (*WhisperAggregation, error) {
 			return nil, err
 		}
 
+		item.tags, err = tags.ParseMatcher(s.ValueOf("tags"))
+		if err != nil {
+			logrus.Errorf("[persister] Failed to parse tags '%s' for [%s]: %s",
+				s.ValueOf("tags"), item.name, err.Error())
+			return nil, err
+		}
+
 		item.xFilesFactor, err = strconv.ParseFloat(s.ValueOf("xFilesFactor"), 64)
 		if err != nil {
 			logrus.Errorf("failed to parse xFilesFactor '%s' in %s: %s",
@@ -111,7 +121,7 @@ func ReadWhisperAggregation(file string) (*WhisperAggregation, error) {
 // Match find schema for metric
 func (a *WhisperAggregation) match(metric string) *whisperAggregationItem {
 	for _, s := range a.Data {
-		if s.pattern.MatchString(metric) {
+		if s.pattern.MatchString(metric) && s.tags.Match(metric) {
 			return s
 		}
 	}
@@ -13,12 +13,15 @@ import (
 
 	"github.com/alyu/configparser"
 	"github.com/lomik/go-whisper"
+
+	"github.com/lomik/go-carbon/tags"
 )
 
 // Schema represents one schema setting
 type Schema struct {
 	Name         string
 	Pattern      *regexp.Regexp
+	Tags         tags.Matcher // optional tag values of tagged series
 	RetentionStr string
 	Retentions   whisper.Retentions
 	Priority     int64
@@ -34,13 +37,31 @@ func (s WhisperSchemas) Less(i, j int) bool { return s[i].Priority >= s[j].Prior
 // Match finds the schema for metric or returns false if none found
 func (s WhisperSchemas) Match(metric string) (Schema, bool) {
 	for _, schema := range s {
-		if schema.Pattern.MatchString(metric) {
+		if schema.Pattern.MatchString(metric) && schema.Tags.Match(metric) {
 			return schema, true
 		}
 	}
 	return Schema{}, false
 }
 
+// MergeRule returns function of step of the highest precision retention and aggregation method of metric.
+// Step is zero for metrics without schema. Used by cache to merge points of the same step
+func MergeRule(schemas WhisperSchemas, aggregation *WhisperAggregation) func(metric string) (int64, string) {
+	return func(metric string) (int64, string) {
+		schema, ok := schemas.Match(metric)
+		if !ok {
+			return 0, ""
+		}
+		var step int64
+		for _, r := range schema.Retentions {
+			if s := int64(r.SecondsPerPoint()); step == 0 || s < step {
+				step = s
+			}
+		}
+		return step, aggregation.match(metric).aggregationMethodStr
+	}
+}
+
 // ParseRetentionDefs parses retention definitions into a Retentions structure
 func ParseRetentionDefs(retentionDefs string) (whisper.Retentions, error) {
 	retentions := make(whisper.Retentions, 0)
@@ -104,6 +125,11 @@ func ReadWhisperSchemas(file string) (WhisperSchemas, error) {
 			return nil, fmt.Errorf("[persister] Failed to parse pattern %q for [%s]: %s",
 				sec.ValueOf("pattern"), schema.Name, err.Error())
 		}
+		schema.Tags, err = tags.ParseMatcher(sec.ValueOf("tags"))
+		if err != nil {
+			return nil, fmt.Errorf("[persister] Failed to parse tags %q for [%s]: %s",
+				sec.ValueOf("tags"), schema.Name, err.Error())
+		}
 		schema.RetentionStr = sec.ValueOf("retentions")
 		schema.Retentions, err = ParseRetentionDefs(schema.RetentionStr)
 
@@ -114,6 +114,35 @@ retentions = 1m:30d,1h:5y
 	)
 }
 
+func TestMergeRule(t *testing.T) {
+	assert := assert.New(t)
+
+	schemas, err := parseSchemas(t, `
+[carbon]
+pattern = ^carbon\.
+retentions = 1h:30d,60s:90d
+
+[default]
+pattern = ^metric\.
+retentions = 10s:1d,1m:30d
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule := MergeRule(schemas, NewWhisperAggregation())
+
+	step, method := rule("carbon.agents")
+	assert.Equal(int64(60), step)
+	assert.Equal("average", method)
+
+	step, _ = rule("metric.name")
+	assert.Equal(int64(10), step)
+
+	step, _ = rule("unknown")
+	assert.Equal(int64(0), step)
+}
+
 func TestParseSchemasComment(t *testing.T) {
 	assertSchemas(t, `
 # This is a wild comment
@@ -178,6 +207,50 @@ priority = 10
 	assert.False(ok)
 }
 
+func TestParseSchemasTags(t *testing.T) {
+	assert := assert.New(t)
+
+	schemas := assertSchemas(t, `
+[web]
+pattern = ^cpu\.
+tags = host=^web dc=^ams$
+retentions = 10s:1d
+priority = 10
+
+[default]
+pattern = .*
+retentions = 1m:30d
+	`,
+		[]testcase{
+			testcase{"web", "^cpu\\.", "10s:1d"},
+			testcase{"default", ".*", "1m:30d"},
+		},
+	)
+
+	matched, ok := schemas.Match("cpu.load;dc=ams;host=web12")
+	if assert.True(ok) {
+		assert.Equal("web", matched.Name)
+	}
+
+	matched, ok = schemas.Match("cpu.load;dc=fra;host=web12")
+	if assert.True(ok) {
+		assert.Equal("default", matched.Name)
+	}
+
+	matched, ok = schemas.Match("cpu.load")
+	if assert.True(ok) {
+		assert.Equal("default", matched.Name)
+	}
+
+	// wrong tags
+	assertSchemas(t, `
+[web]
+pattern = ^cpu\.
+tags = host
+retentions = 10s:1d
+`, nil, "Wrong tags")
+}
+
 func TestSchemasNotFound(t *testing.T) {
 	// create and remove file
 	assert := assert.New(t)
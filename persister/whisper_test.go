@@ -1,6 +1,10 @@
 package persister
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
 	"sync"
 
 	"github.com/lomik/go-carbon/points"
@@ -25,10 +29,88 @@ func TestNewWhisper(t *testing.T) {
 		workersCount:   1,
 		rootPath:       "foo",
 		metricInterval: time.Minute,
+		openFilesIdle:  time.Minute,
 	}
 	assert.Equal(t, *output, expected)
 }
 
+func TestStoreOutsideDataDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "go-carbon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	root := filepath.Join(tmpDir, "data")
+	if err = os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	retentions, err := ParseRetentionDefs("60:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas := WhisperSchemas{{Name: "default", Pattern: regexp.MustCompile(".*"), Retentions: retentions}}
+
+	p := NewWhisper(root, schemas, NewWhisperAggregation(), nil, nil)
+	store(p, newFileCache(0, time.Minute), points.OnePoint("a./../../escaped", 1, time.Now().Unix()))
+
+	_, err = os.Stat(filepath.Join(tmpDir, "escaped.wsp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCreateDeferred(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "go-carbon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	retentions, err := ParseRetentionDefs("60:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas := WhisperSchemas{{Name: "default", Pattern: regexp.MustCompile(".*"), Retentions: retentions}}
+
+	confirm := make(chan *points.Points, 1)
+	p := NewWhisper(tmpDir, schemas, NewWhisperAggregation(), nil, confirm)
+	p.SetMaxCreatesPerSecond(10)
+	p.createLimiter.tokens = 0
+
+	var requeued *points.Points
+	var notBefore time.Time
+	p.SetRequeue(func(values *points.Points, t time.Time) {
+		requeued = values
+		notBefore = t
+	})
+
+	values := points.OnePoint("hello.world", 42, 10)
+	store(p, newFileCache(0, time.Minute), values)
+
+	// points are returned to cache and original is confirmed
+	assert.True(requeued == values)
+	assert.True(notBefore.After(time.Now()))
+	assert.True(<-confirm == values)
+	assert.Equal(uint32(1), p.createDeferred)
+
+	// creation is retried after delay
+	requeued = nil
+	time.Sleep(notBefore.Sub(time.Now()))
+	_, err = open(p, values, filepath.Join(tmpDir, "hello/world.wsp"))
+	assert.NotEqual(errCreateDeferred, err)
+	assert.Nil(requeued)
+
+	// without requeue function worker waits for limiter
+	p.SetRequeue(nil)
+	p.createLimiter.tokens = 0
+	start := time.Now()
+	_, err = open(p, values, filepath.Join(tmpDir, "hello/world.wsp"))
+	assert.NotEqual(errCreateDeferred, err)
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+}
+
 func TestSetGraphPrefix(t *testing.T) {
 	fixture := Whisper{}
 	fixture.SetGraphPrefix("foo.bar")
@@ -0,0 +1,102 @@
+package points
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lomik/go-carbon/tags"
+)
+
+// metrics20Tags parses space separated "key=value" tags
+func metrics20Tags(s string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	for _, tag := range strings.Split(s, " ") {
+		if tag == "" {
+			continue
+		}
+		p := strings.IndexByte(tag, '=')
+		if p <= 0 || p == len(tag)-1 {
+			return nil, fmt.Errorf("bad tag %#v", tag)
+		}
+		if _, exists := result[tag[:p]]; exists {
+			return nil, fmt.Errorf("duplicate tag %#v", tag[:p])
+		}
+		result[tag[:p]] = tag[p+1:]
+	}
+
+	return result, nil
+}
+
+// ParseMetrics20 parse metrics 2.0 line: intrinsic tags, two spaces, meta tags, value and timestamp:
+// "unit=B mtype=gauge what=disk_space host=web01  agent=diamond 42 1422641531\n".
+// Name of series is "what" tag, other intrinsic tags become tags of graphite tagged series:
+// "disk_space;host=web01;mtype=gauge;unit=B". "what" is optional: without it name is built from sorted
+// intrinsic tags in graphite style of metrics 2.0: "host_is_web01.mtype_is_gauge.unit_is_B".
+// Meta tags do not identify series and are dropped
+func ParseMetrics20(line string) (*Points, error) {
+	line = strings.Trim(line, "\n \t\r")
+
+	p := strings.Index(line, "  ")
+	if p <= 0 {
+		return nil, fmt.Errorf("bad message: %#v", line)
+	}
+
+	intrinsic, err := metrics20Tags(line[:p])
+	if err != nil {
+		return nil, fmt.Errorf("bad message: %#v: %s", line, err.Error())
+	}
+
+	row := strings.Split(strings.TrimLeft(line[p:], " "), " ")
+	if len(row) < 2 {
+		return nil, fmt.Errorf("bad message: %#v", line)
+	}
+
+	if _, err := metrics20Tags(strings.Join(row[:len(row)-2], " ")); err != nil {
+		return nil, fmt.Errorf("bad message: %#v: %s", line, err.Error())
+	}
+
+	value, err := strconv.ParseFloat(row[len(row)-2], 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, fmt.Errorf("bad message: %#v", line)
+	}
+
+	tsf, err := strconv.ParseFloat(row[len(row)-1], 64)
+	if err != nil || math.IsNaN(tsf) {
+		return nil, fmt.Errorf("bad message: %#v", line)
+	}
+
+	what := intrinsic["what"]
+	delete(intrinsic, "what")
+
+	separator := "="
+	if what == "" {
+		separator = "_is_"
+	}
+
+	list := make([]string, 0, len(intrinsic))
+	for k, v := range intrinsic {
+		list = append(list, k+separator+v)
+	}
+	sort.Strings(list)
+
+	var name string
+	switch {
+	case what == "":
+		name = strings.Join(list, ".")
+	case len(list) > 0:
+		name = what + ";" + strings.Join(list, ";")
+	default:
+		name = what
+	}
+
+	name, err = tags.Normalize(name)
+	if err != nil {
+		return nil, fmt.Errorf("bad message: %#v: %s", line, err.Error())
+	}
+
+	return OnePoint(name, value, int64(tsf)), nil
+}
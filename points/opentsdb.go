@@ -0,0 +1,97 @@
+package points
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lomik/go-carbon/tags"
+)
+
+// OpenTSDBFormat converts OpenTSDB metric with tags to graphite name
+type OpenTSDBFormat struct {
+	// TagOrder lists tags placed after metric name: "sys.cpu.user" with host=web01 cpu=0
+	// and order [host cpu] is "sys.cpu.user.web01.0". Tags missing in line are skipped,
+	// tags not listed are appended sorted by tag name
+	TagOrder []string
+	// Tagged enables graphite tagged series "sys.cpu.user;cpu=0;host=web01"
+	Tagged bool
+}
+
+func (f *OpenTSDBFormat) name(metric string, tagMap map[string]string) string {
+	if f.Tagged {
+		list := make([]string, 0, len(tagMap)+1)
+		list = append(list, metric)
+		for k, v := range tagMap {
+			list = append(list, k+"="+v)
+		}
+		return strings.Join(list, ";")
+	}
+
+	parts := []string{metric}
+	used := make(map[string]bool, len(f.TagOrder))
+
+	for _, k := range f.TagOrder {
+		if v, ok := tagMap[k]; ok && !used[k] {
+			parts = append(parts, v)
+			used[k] = true
+		}
+	}
+
+	rest := make([]string, 0, len(tagMap))
+	for k := range tagMap {
+		if !used[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+
+	for _, k := range rest {
+		parts = append(parts, tagMap[k])
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// ParseOpenTSDB parse OpenTSDB telnet protocol Point:
+// "put sys.cpu.user 1356998400 42.5 host=web01 cpu=0\n".
+// Timestamps in milliseconds are converted to seconds
+func ParseOpenTSDB(line string, format *OpenTSDBFormat) (*Points, error) {
+	row := strings.Fields(line)
+	if len(row) < 4 || row[0] != "put" {
+		return nil, fmt.Errorf("bad message: %#v", line)
+	}
+
+	ts, err := strconv.ParseInt(row[2], 10, 64)
+	if err != nil || ts < 0 {
+		return nil, fmt.Errorf("bad message: %#v", line)
+	}
+
+	// 13 digits are milliseconds
+	if ts > 9999999999 {
+		ts = ts / 1000
+	}
+
+	value, err := strconv.ParseFloat(row[3], 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, fmt.Errorf("bad message: %#v", line)
+	}
+
+	tagMap := make(map[string]string, len(row)-4)
+	for _, tag := range row[4:] {
+		p := strings.IndexByte(tag, '=')
+		if p <= 0 || p == len(tag)-1 {
+			return nil, fmt.Errorf("bad message: %#v", line)
+		}
+		tagMap[tag[:p]] = tag[p+1:]
+	}
+
+	name, err := tags.Normalize(format.name(row[1], tagMap))
+	if err != nil {
+		return nil, fmt.Errorf("bad message: %#v: %s", line, err.Error())
+	}
+
+	return OnePoint(name, value, ts), nil
+}
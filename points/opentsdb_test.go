@@ -0,0 +1,51 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOpenTSDB(t *testing.T) {
+	assert := assert.New(t)
+
+	line := "put sys.cpu.user 1356998400 42.5 host=web01 cpu=0 dc=eu\n"
+
+	table := []struct {
+		format *OpenTSDBFormat
+		metric string
+	}{
+		{&OpenTSDBFormat{}, "sys.cpu.user.0.eu.web01"},
+		{&OpenTSDBFormat{TagOrder: []string{"host", "cpu"}}, "sys.cpu.user.web01.0.eu"},
+		{&OpenTSDBFormat{TagOrder: []string{"dc", "unknown", "host"}}, "sys.cpu.user.eu.web01.0"},
+		{&OpenTSDBFormat{Tagged: true}, "sys.cpu.user;cpu=0;dc=eu;host=web01"},
+	}
+
+	for _, c := range table {
+		p, err := ParseOpenTSDB(line, c.format)
+		if assert.NoError(err) {
+			assert.Equal(OnePoint(c.metric, 42.5, 1356998400), p)
+		}
+	}
+
+	p, err := ParseOpenTSDB("put sys.load 1356998400123 1", &OpenTSDBFormat{})
+	if assert.NoError(err) {
+		assert.Equal(OnePoint("sys.load", 1, 1356998400), p)
+	}
+
+	bad := []string{
+		"",
+		"version",
+		"get sys.load 1356998400 1",
+		"put sys.load 1356998400",
+		"put sys.load abc 1",
+		"put sys.load 1356998400 abc",
+		"put sys.load 1356998400 1 host",
+		"put sys.load 1356998400 1 host=",
+	}
+
+	for _, line := range bad {
+		_, err := ParseOpenTSDB(line, &OpenTSDBFormat{})
+		assert.Error(err, line)
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hydrogen18/stalecucumber"
+
+	"github.com/lomik/go-carbon/tags"
 )
 
 // Point value/time pair
@@ -57,8 +60,13 @@ func (p *Points) Copy() *Points {
 
 // ParseText parse text protocol Point
 //  host.Point.value 42 1422641531\n
+// Lines with two spaces in a row are parsed as metrics 2.0 by ParseMetrics20
 func ParseText(line string) (*Points, error) {
 
+	if strings.Contains(strings.Trim(line, "\n \t\r"), "  ") {
+		return ParseMetrics20(line)
+	}
+
 	row := strings.Split(strings.Trim(line, "\n \t\r"), " ")
 	if len(row) != 3 {
 		return nil, fmt.Errorf("bad message: %#v", line)
@@ -92,7 +100,25 @@ func ParseText(line string) (*Points, error) {
 	// 	return nil, fmt.Errorf("bad message: %#v", line)
 	// }
 
-	return OnePoint(row[0], value, int64(tsf)), nil
+	name, err := tags.Normalize(row[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad message: %#v: %s", line, err.Error())
+	}
+
+	return OnePoint(name, value, int64(tsf)), nil
+}
+
+// WriteTo writes points in text protocol. One line per point
+func (p *Points) WriteTo(w io.Writer) (n int64, err error) {
+	var c int
+	for _, d := range p.Data {
+		c, err = fmt.Fprintf(w, "%s %s %d\n", p.Metric, strconv.FormatFloat(d.Value, 'f', -1, 64), d.Timestamp)
+		n += int64(c)
+		if err != nil {
+			return
+		}
+	}
+	return
 }
 
 // ParsePickle ...
@@ -120,6 +146,11 @@ func ParsePickle(pkt []byte) ([]*Points, error) {
 			return nil, err
 		}
 
+		name, err = tags.Normalize(name)
+		if err != nil {
+			return nil, err
+		}
+
 		msg := New()
 		msg.Metric = name
 
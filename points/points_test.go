@@ -1,6 +1,7 @@
 package points
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 	"time"
@@ -54,12 +55,34 @@ func TestParseText(t *testing.T) {
 	assertError("metric.name NaN 1422642189\n")
 	assertError("metric.name 42 NaN\n")
 
+	assertError("cpu.load;host 42 1422642189\n")
+
+	assertOk("cpu.load;host=a;dc=x 42 1422642189\n",
+		OnePoint("cpu.load;dc=x;host=a", 42, 1422642189))
+
 	assertOk("metric.name -42.76 1422642189\n",
 		OnePoint("metric.name", -42.76, 1422642189))
 
 	assertOk("metric.name 42.15 1422642189\n",
 		OnePoint("metric.name", 42.15, 1422642189))
 
+	// metrics 2.0
+	assertOk("unit=B mtype=gauge what=disk_space host=web01  agent=diamond src=df 42 1422642189\n",
+		OnePoint("disk_space;host=web01;mtype=gauge;unit=B", 42, 1422642189))
+
+	assertOk("what=load  1.5 1422642189\n",
+		OnePoint("load", 1.5, 1422642189))
+
+	// "what" is optional
+	assertOk("unit=B mtype=gauge server=web01 direction=in  agent=diamond 42 1422642189\n",
+		OnePoint("direction_is_in.mtype_is_gauge.server_is_web01.unit_is_B", 42, 1422642189))
+
+	assertError("what=load host  42 1422642189\n")
+	assertError("what=load  agent 42 1422642189\n")
+	assertError("what=load host=a host=b  42 1422642189\n")
+	assertError("what=load  42\n")
+	assertError("what=load  NaN 1422642189\n")
+
 }
 
 func TestCopyAndEq(t *testing.T) {
@@ -124,6 +147,17 @@ func TestCopyAndEq(t *testing.T) {
 	)
 }
 
+func TestWriteTo(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := new(bytes.Buffer)
+	n, err := OnePoint("hello.world", 42.15, 1422698155).Add(-1, 1422698215).WriteTo(buf)
+
+	assert.NoError(err)
+	assert.Equal("hello.world 42.15 1422698155\nhello.world -1 1422698215\n", buf.String())
+	assert.Equal(int64(buf.Len()), n)
+}
+
 type testcase struct {
 	description string
 	input       []byte
@@ -0,0 +1,216 @@
+package points
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lomik/go-carbon/tags"
+)
+
+// Prometheus remote write schema:
+//
+//  message WriteRequest {
+//      repeated TimeSeries timeseries = 1;
+//  }
+//
+//  message TimeSeries {
+//      repeated Label labels = 1;
+//      repeated Sample samples = 2;
+//  }
+//
+//  message Label {
+//      string name = 1;
+//      string value = 2;
+//  }
+//
+//  message Sample {
+//      double value = 1;
+//      int64 timestamp = 2; // milliseconds
+//  }
+
+var errBadWriteRequest = errors.New("bad prometheus write request")
+
+var prometheusBadChars = regexp.MustCompile(`[^a-zA-Z0-9_:\-]`)
+
+// prometheusBadTagChars break tagged series or text protocol line
+var prometheusBadTagChars = regexp.MustCompile(`[;~[:space:][:cntrl:]]`)
+
+type prometheusLabel struct {
+	name  string
+	value string
+}
+
+type prometheusLabelSorter []prometheusLabel
+
+func (v prometheusLabelSorter) Len() int           { return len(v) }
+func (v prometheusLabelSorter) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v prometheusLabelSorter) Less(i, j int) bool { return v[i].name < v[j].name }
+
+func parsePrometheusLabel(data []byte) (prometheusLabel, error) {
+	var l prometheusLabel
+
+	err := protobufFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		body, _ := protowire.ConsumeBytes(value)
+
+		switch num {
+		case 1:
+			l.name = string(body)
+		case 2:
+			l.value = string(body)
+		}
+		return nil
+	})
+
+	return l, err
+}
+
+func parsePrometheusSample(data []byte) (*Point, error) {
+	var ms int64
+	p := &Point{}
+
+	err := protobufFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, _ := protowire.ConsumeFixed64(value)
+			p.Value = math.Float64frombits(v)
+		case num == 2 && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(value)
+			ms = int64(v)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.Timestamp = ms / 1000
+	return p, nil
+}
+
+// prometheusName returns "name.label1.value1.label2.value2" with labels sorted by name
+// or tagged series "name;label1=value1;label2=value2". Returns false if series can't be named
+func prometheusName(labels []prometheusLabel, tagged bool) (string, bool) {
+	var name string
+	other := make([]prometheusLabel, 0, len(labels))
+
+	for _, l := range labels {
+		if l.name == "__name__" {
+			name = l.value
+		} else if l.value != "" {
+			other = append(other, l)
+		}
+	}
+
+	if name == "" {
+		return "", false
+	}
+
+	sort.Sort(prometheusLabelSorter(other))
+
+	if tagged {
+		parts := []string{prometheusBadTagChars.ReplaceAllString(name, "_")}
+		for _, l := range other {
+			parts = append(parts,
+				prometheusBadChars.ReplaceAllString(l.name, "_")+"="+prometheusBadTagChars.ReplaceAllString(l.value, "_"),
+			)
+		}
+		metric, err := tags.Normalize(strings.Join(parts, ";"))
+		return metric, err == nil
+	}
+
+	parts := []string{prometheusBadChars.ReplaceAllString(name, "_")}
+	for _, l := range other {
+		parts = append(parts,
+			prometheusBadChars.ReplaceAllString(l.name, "_"),
+			prometheusBadChars.ReplaceAllString(l.value, "_"),
+		)
+	}
+	return strings.Join(parts, "."), true
+}
+
+// parsePrometheusSeries returns nil Points for series which can't be named
+func parsePrometheusSeries(data []byte, tagged bool) (*Points, error) {
+	msg := New()
+	var labels []prometheusLabel
+
+	err := protobufFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		body, _ := protowire.ConsumeBytes(value)
+
+		switch num {
+		case 1:
+			l, err := parsePrometheusLabel(body)
+			if err != nil {
+				return err
+			}
+			labels = append(labels, l)
+		case 2:
+			p, err := parsePrometheusSample(body)
+			if err != nil {
+				return err
+			}
+			// NaN is staleness marker
+			if !math.IsNaN(p.Value) {
+				msg.Data = append(msg.Data, p)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if msg.Metric, ok = prometheusName(labels, tagged); !ok {
+		return nil, nil
+	}
+
+	return msg, nil
+}
+
+// ParsePrometheusWriteRequest parses uncompressed WriteRequest message.
+// All samples of series are returned in one Points. Series without name or with bad labels are
+// skipped, their count is returned
+func ParsePrometheusWriteRequest(data []byte, tagged bool) ([]*Points, int, error) {
+	msgs := []*Points{}
+	skipped := 0
+
+	err := protobufFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		body, _ := protowire.ConsumeBytes(value)
+
+		msg, err := parsePrometheusSeries(body, tagged)
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			skipped++
+			return nil
+		}
+		if len(msg.Data) > 0 {
+			msgs = append(msgs, msg)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %s", errBadWriteRequest.Error(), err.Error())
+	}
+
+	return msgs, skipped, nil
+}
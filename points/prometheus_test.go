@@ -0,0 +1,111 @@
+package points
+
+import (
+	"math"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func prometheusLabelMsg(name, value string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, value)
+	return b
+}
+
+func prometheusSampleMsg(value float64, ms int64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(value))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(ms))
+	return b
+}
+
+func prometheusSeriesMsg(labels [][]byte, samples [][]byte) []byte {
+	var b []byte
+	for _, l := range labels {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, l)
+	}
+	for _, s := range samples {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, s)
+	}
+	return b
+}
+
+func prometheusWriteRequestMsg(series ...[]byte) []byte {
+	var b []byte
+	for _, s := range series {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, s)
+	}
+	return b
+}
+
+func TestParsePrometheusWriteRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	body := prometheusWriteRequestMsg(
+		prometheusSeriesMsg(
+			[][]byte{
+				prometheusLabelMsg("job", "node"),
+				prometheusLabelMsg("__name__", "up"),
+				prometheusLabelMsg("instance", "web01.example.com:9100"),
+			},
+			[][]byte{
+				prometheusSampleMsg(1, 1422698155123),
+				prometheusSampleMsg(math.NaN(), 1422698165000),
+				prometheusSampleMsg(0, 1422698175000),
+			},
+		),
+		prometheusSeriesMsg(
+			[][]byte{prometheusLabelMsg("__name__", "empty")},
+			nil,
+		),
+	)
+
+	msgs, skipped, err := ParsePrometheusWriteRequest(body, false)
+	assert.NoError(err)
+	assert.Equal(0, skipped)
+	assert.Equal([]*Points{
+		&Points{
+			Metric: "up.instance.web01_example_com:9100.job.node",
+			Data: []*Point{
+				&Point{Value: 1, Timestamp: 1422698155},
+				&Point{Value: 0, Timestamp: 1422698175},
+			},
+		},
+	}, msgs)
+
+	msgs, _, err = ParsePrometheusWriteRequest(body, true)
+	assert.NoError(err)
+	assert.Equal("up;instance=web01.example.com:9100;job=node", msgs[0].Metric)
+
+	sample := [][]byte{prometheusSampleMsg(1, 1422698155000)}
+
+	// tag values are escaped, series without name is skipped
+	msgs, skipped, err = ParsePrometheusWriteRequest(prometheusWriteRequestMsg(
+		prometheusSeriesMsg([][]byte{prometheusLabelMsg("job", "node")}, sample),
+		prometheusSeriesMsg([][]byte{
+			prometheusLabelMsg("__name__", "up"),
+			prometheusLabelMsg("a", "~x"),
+			prometheusLabelMsg("b", "a=b;c=d"),
+			prometheusLabelMsg("c", "with space\n"),
+		}, sample),
+	), true)
+	assert.NoError(err)
+	assert.Equal(1, skipped)
+	if assert.Len(msgs, 1) {
+		assert.Equal("up;a=_x;b=a=b_c=d;c=with_space_", msgs[0].Metric)
+	}
+
+	_, _, err = ParsePrometheusWriteRequest([]byte{0x0a, 0xff}, false)
+	assert.Error(err)
+}
@@ -0,0 +1,140 @@
+package points
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lomik/go-carbon/tags"
+)
+
+// Protobuf schema:
+//
+//  message Point {
+//      uint32 timestamp = 1;
+//      double value = 2;
+//  }
+//
+//  message Metric {
+//      string metric = 1;
+//      repeated Point points = 2;
+//  }
+//
+//  message Payload {
+//      repeated Metric metrics = 1;
+//  }
+
+var errBadProtobuf = errors.New("bad protobuf message")
+
+// protobufFields calls callback for each field of message
+func protobufFields(data []byte, callback func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		m := protowire.ConsumeFieldValue(num, typ, data)
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+
+		if err := callback(num, typ, data[:m]); err != nil {
+			return err
+		}
+		data = data[m:]
+	}
+	return nil
+}
+
+func parseProtobufPoint(data []byte) (*Point, error) {
+	p := &Point{}
+
+	err := protobufFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(value)
+			p.Timestamp = int64(uint32(v))
+		case num == 2 && typ == protowire.Fixed64Type:
+			v, _ := protowire.ConsumeFixed64(value)
+			p.Value = math.Float64frombits(v)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(p.Value) {
+		return nil, errBadProtobuf
+	}
+	return p, nil
+}
+
+func parseProtobufMetric(data []byte) (*Points, error) {
+	msg := New()
+
+	err := protobufFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		body, _ := protowire.ConsumeBytes(value)
+
+		switch num {
+		case 1:
+			msg.Metric = string(body)
+		case 2:
+			p, err := parseProtobufPoint(body)
+			if err != nil {
+				return err
+			}
+			msg.Data = append(msg.Data, p)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := tags.Normalize(msg.Metric)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errBadProtobuf
+	}
+	msg.Metric = name
+
+	return msg, nil
+}
+
+// ParseProtobuf parses Payload message
+func ParseProtobuf(data []byte) ([]*Points, error) {
+	msgs := []*Points{}
+
+	err := protobufFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		body, _ := protowire.ConsumeBytes(value)
+
+		msg, err := parseProtobufMetric(body)
+		if err != nil {
+			return err
+		}
+		if len(msg.Data) > 0 {
+			msgs = append(msgs, msg)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", errBadProtobuf.Error(), err.Error())
+	}
+
+	return msgs, nil
+}
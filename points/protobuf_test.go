@@ -0,0 +1,72 @@
+package points
+
+import (
+	"math"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func protobufPoint(timestamp uint32, value float64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(timestamp))
+	b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(value))
+	return b
+}
+
+func protobufMetric(name string, pointList ...[]byte) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	for _, p := range pointList {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, p)
+	}
+	return b
+}
+
+func protobufPayload(metrics ...[]byte) []byte {
+	var b []byte
+	for _, m := range metrics {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+	return b
+}
+
+func TestParseProtobuf(t *testing.T) {
+	assert := assert.New(t)
+
+	msgs, err := ParseProtobuf(protobufPayload(
+		protobufMetric("hello.world", protobufPoint(1423931224, 42.1), protobufPoint(1423931225, -1)),
+		protobufMetric("cpu.load;host=a;dc=x", protobufPoint(1423931224, 0.5)),
+	))
+
+	assert.NoError(err)
+	if assert.Len(msgs, 2) {
+		expected := OnePoint("hello.world", 42.1, 1423931224).Add(-1, 1423931225)
+		assert.True(msgs[0].Eq(expected))
+		assert.True(msgs[1].Eq(OnePoint("cpu.load;dc=x;host=a", 0.5, 1423931224)))
+	}
+
+	// empty payload
+	msgs, err = ParseProtobuf(nil)
+	assert.NoError(err)
+	assert.Empty(msgs)
+
+	bad := [][]byte{
+		[]byte("\x0a\xff"),
+		protobufPayload(protobufMetric("", protobufPoint(1423931224, 1))),
+		protobufPayload(protobufMetric("nan.value", protobufPoint(1423931224, math.NaN()))),
+		protobufPayload(protobufMetric("bad;tag", protobufPoint(1423931224, 1))),
+	}
+
+	for _, data := range bad {
+		_, err := ParseProtobuf(data)
+		assert.Error(err)
+	}
+}
@@ -0,0 +1,150 @@
+package points
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Reasons of invalid metric names
+const (
+	ReasonEmptyComponent = iota // "a..b", ".a" or "a."
+	ReasonSlash                 // "/" makes path of whisper file outside of data dir
+	ReasonControlChar           // chars below 0x20 and 0x7f
+	ReasonTooLong               // name or file name of whisper file longer than limit
+	ReasonCount
+)
+
+var reasonNames = [ReasonCount]string{"empty_component", "slash", "control_char", "too_long"}
+
+// ReasonName returns name of reason for internal metrics
+func ReasonName(reason int) string {
+	return reasonNames[reason]
+}
+
+// maxFileNameLength is limit of file name length in most file systems
+const maxFileNameLength = 255
+
+// Validator checks metric names before they are written to whisper files.
+// Invalid names are rejected or normalized
+type Validator struct {
+	normalize  bool
+	maxLength  int
+	rejected   [ReasonCount]uint64
+	normalized [ReasonCount]uint64
+}
+
+// NewValidator create new instance of Validator
+func NewValidator() *Validator {
+	return &Validator{
+		maxLength: 1024,
+	}
+}
+
+// SetNormalize enables fixing of names instead of rejecting: empty components are removed,
+// slashes and control chars are replaced by "_". Too long names are always rejected
+func (v *Validator) SetNormalize(normalize bool) {
+	v.normalize = normalize
+}
+
+// SetMaxLength sets limit of metric name length. 0 - unlimited
+func (v *Validator) SetMaxLength(maxLength int) {
+	v.maxLength = maxLength
+}
+
+func (v *Validator) reject(reason int) (string, bool) {
+	atomic.AddUint64(&v.rejected[reason], 1)
+	return "", false
+}
+
+// Validate returns valid (possibly normalized) metric name. Returns false if metric is rejected
+func (v *Validator) Validate(metric string) (string, bool) {
+	if strings.IndexFunc(metric, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		if !v.normalize {
+			return v.reject(ReasonControlChar)
+		}
+		atomic.AddUint64(&v.normalized[ReasonControlChar], 1)
+		metric = strings.Map(func(r rune) rune {
+			if r < 0x20 || r == 0x7f {
+				return '_'
+			}
+			return r
+		}, metric)
+	}
+
+	if strings.IndexByte(metric, '/') >= 0 {
+		if !v.normalize {
+			return v.reject(ReasonSlash)
+		}
+		atomic.AddUint64(&v.normalized[ReasonSlash], 1)
+		metric = strings.Replace(metric, "/", "_", -1)
+	}
+
+	// components of tagged series are in name only
+	name, tagList := metric, ""
+	if p := strings.IndexByte(metric, ';'); p >= 0 {
+		name, tagList = metric[:p], metric[p:]
+	}
+
+	parts := strings.Split(name, ".")
+	for _, part := range parts {
+		if part != "" {
+			continue
+		}
+
+		if !v.normalize {
+			return v.reject(ReasonEmptyComponent)
+		}
+		atomic.AddUint64(&v.normalized[ReasonEmptyComponent], 1)
+
+		nonEmpty := parts[:0]
+		for _, p := range parts {
+			if p != "" {
+				nonEmpty = append(nonEmpty, p)
+			}
+		}
+		if len(nonEmpty) == 0 {
+			return v.reject(ReasonEmptyComponent)
+		}
+
+		parts = nonEmpty
+		metric = strings.Join(parts, ".") + tagList
+		break
+	}
+
+	if v.maxLength > 0 && len(metric) > v.maxLength {
+		return v.reject(ReasonTooLong)
+	}
+
+	if tagList != "" {
+		// whole tagged series is file name with "." replaced by "_DOT_"
+		if len(metric)+4*strings.Count(metric, ".")+len(".wsp") > maxFileNameLength {
+			return v.reject(ReasonTooLong)
+		}
+	} else {
+		for _, part := range parts {
+			if len(part)+len(".wsp") > maxFileNameLength {
+				return v.reject(ReasonTooLong)
+			}
+		}
+	}
+
+	return metric, true
+}
+
+// Rejected returns and resets count of rejected metrics by reason
+func (v *Validator) Rejected() [ReasonCount]uint64 {
+	var result [ReasonCount]uint64
+	for i := range v.rejected {
+		result[i] = atomic.SwapUint64(&v.rejected[i], 0)
+	}
+	return result
+}
+
+// Normalized returns and resets count of normalized metrics by reason
+func (v *Validator) Normalized() [ReasonCount]uint64 {
+	var result [ReasonCount]uint64
+	for i := range v.normalized {
+		result[i] = atomic.SwapUint64(&v.normalized[i], 0)
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package points
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		metric     string
+		reason     int // -1 - valid
+		normalized string
+	}{
+		{"a.b.c", -1, "a.b.c"},
+		{"a.b;host=web01.example.com", -1, "a.b;host=web01.example.com"},
+		{"a...b", ReasonEmptyComponent, "a.b"},
+		{".a.b.", ReasonEmptyComponent, "a.b"},
+		{"a..b;host=x", ReasonEmptyComponent, "a.b;host=x"},
+		{"...", ReasonEmptyComponent, ""},
+		{"", ReasonEmptyComponent, ""},
+		{"a./../../etc/passwd", ReasonSlash, "a._._._etc_passwd"},
+		{"a.b;host=../../x", ReasonSlash, "a.b;host=.._.._x"},
+		{"a.b\x00c", ReasonControlChar, "a.b_c"},
+		{"a." + strings.Repeat("x", 252), ReasonTooLong, ""},
+		{strings.Repeat("a.", 600) + "b", ReasonTooLong, ""},
+		{"a;host=" + strings.Repeat("x.", 50), ReasonTooLong, ""},
+	}
+
+	for _, c := range table {
+		v := NewValidator()
+		metric, ok := v.Validate(c.metric)
+		rejected := v.Rejected()
+		if c.reason < 0 {
+			assert.True(ok, c.metric)
+			assert.Equal(c.metric, metric)
+			assert.Equal([ReasonCount]uint64{}, rejected)
+		} else {
+			assert.False(ok, c.metric)
+			assert.Equal(uint64(1), rejected[c.reason], c.metric)
+		}
+
+		v.SetNormalize(true)
+		metric, ok = v.Validate(c.metric)
+		assert.Equal(c.normalized != "", ok, c.metric)
+		assert.Equal(c.normalized, metric, c.metric)
+	}
+
+	v := NewValidator()
+	v.SetNormalize(true)
+	v.Validate("a..b/c")
+	v.Validate("a..b")
+	assert.Equal([ReasonCount]uint64{2, 1, 0, 0}, v.Normalized())
+	assert.Equal([ReasonCount]uint64{}, v.Normalized())
+
+	v.SetMaxLength(0)
+	_, ok := v.Validate(strings.Repeat("a.", 600) + "b")
+	assert.True(ok)
+}
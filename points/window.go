@@ -0,0 +1,114 @@
+package points
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// WindowAction is action for points outside of timestamp window
+type WindowAction int
+
+const (
+	// WindowReject drops points
+	WindowReject WindowAction = iota
+	// WindowClamp moves timestamp to nearest edge of window
+	WindowClamp
+	// WindowReceiveTime replaces timestamp by time of receive
+	WindowReceiveTime
+)
+
+var windowActionNames = []string{"rejected", "clamped", "replaced"}
+
+// ParseWindowAction parses "reject", "clamp" or "receive-time"
+func ParseWindowAction(action string) (WindowAction, error) {
+	switch action {
+	case "reject":
+		return WindowReject, nil
+	case "clamp":
+		return WindowClamp, nil
+	case "receive-time":
+		return WindowReceiveTime, nil
+	}
+	return WindowReject, fmt.Errorf("unknown timestamp window action %#v", action)
+}
+
+// TimestampWindow checks that timestamps of points are in [now - maxPast, now + maxFuture]
+type TimestampWindow struct {
+	maxFuture time.Duration // 0 - unlimited
+	maxPast   time.Duration // 0 - unlimited
+	action    WindowAction
+	future    uint64 // counter
+	past      uint64 // counter
+}
+
+// NewTimestampWindow create new instance of TimestampWindow
+func NewTimestampWindow(maxFuture, maxPast time.Duration, action WindowAction) *TimestampWindow {
+	return &TimestampWindow{
+		maxFuture: maxFuture,
+		maxPast:   maxPast,
+		action:    action,
+	}
+}
+
+// Check applies action to points outside of window. Returns false if no points left
+func (w *TimestampWindow) Check(p *Points, now int64) bool {
+	var data []*Point
+
+	for i, d := range p.Data {
+		var edge int64
+
+		switch {
+		case w.maxFuture > 0 && d.Timestamp > now+int64(w.maxFuture.Seconds()):
+			atomic.AddUint64(&w.future, 1)
+			edge = now + int64(w.maxFuture.Seconds())
+		case w.maxPast > 0 && d.Timestamp < now-int64(w.maxPast.Seconds()):
+			atomic.AddUint64(&w.past, 1)
+			edge = now - int64(w.maxPast.Seconds())
+		default:
+			if data != nil {
+				data = append(data, d)
+			}
+			continue
+		}
+
+		switch w.action {
+		case WindowClamp:
+			d.Timestamp = edge
+		case WindowReceiveTime:
+			d.Timestamp = now
+		default:
+			// copy accepted points on first rejected
+			if data == nil {
+				data = make([]*Point, i, len(p.Data))
+				copy(data, p.Data[:i])
+			}
+			continue
+		}
+
+		if data != nil {
+			data = append(data, d)
+		}
+	}
+
+	if data != nil {
+		p.Data = data
+	}
+
+	return len(p.Data) > 0
+}
+
+// ActionName returns name of action for internal metrics: "rejected", "clamped" or "replaced"
+func (w *TimestampWindow) ActionName() string {
+	return windowActionNames[w.action]
+}
+
+// Future returns and resets count of points from future
+func (w *TimestampWindow) Future() uint64 {
+	return atomic.SwapUint64(&w.future, 0)
+}
+
+// Past returns and resets count of too old points
+func (w *TimestampWindow) Past() uint64 {
+	return atomic.SwapUint64(&w.past, 0)
+}
@@ -0,0 +1,70 @@
+package points
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	now := int64(1422698155)
+
+	newPoints := func() *Points {
+		return &Points{
+			Metric: "hello.world",
+			Data: []*Point{
+				&Point{Value: 1, Timestamp: now - 7200},
+				&Point{Value: 2, Timestamp: now},
+				&Point{Value: 3, Timestamp: now + 7200},
+				&Point{Value: 4, Timestamp: now + 60},
+			},
+		}
+	}
+
+	timestamps := func(p *Points) []int64 {
+		var result []int64
+		for _, d := range p.Data {
+			result = append(result, d.Timestamp)
+		}
+		return result
+	}
+
+	table := []struct {
+		action     WindowAction
+		timestamps []int64
+	}{
+		{WindowReject, []int64{now, now + 60}},
+		{WindowClamp, []int64{now - 3600, now, now + 600, now + 60}},
+		{WindowReceiveTime, []int64{now, now, now, now + 60}},
+	}
+
+	for _, c := range table {
+		w := NewTimestampWindow(10*time.Minute, time.Hour, c.action)
+		p := newPoints()
+		assert.True(w.Check(p, now))
+		assert.Equal(c.timestamps, timestamps(p), w.ActionName())
+		assert.Equal(uint64(1), w.Future())
+		assert.Equal(uint64(1), w.Past())
+		assert.Equal(uint64(0), w.Future())
+	}
+
+	// unlimited
+	w := NewTimestampWindow(0, 0, WindowReject)
+	p := newPoints()
+	assert.True(w.Check(p, now))
+	assert.Len(p.Data, 4)
+
+	// all rejected
+	w = NewTimestampWindow(time.Minute, 0, WindowReject)
+	p = OnePoint("hello.world", 1, now+3600)
+	assert.False(w.Check(p, now))
+
+	_, err := ParseWindowAction("drop")
+	assert.Error(err)
+	a, err := ParseWindowAction("receive-time")
+	assert.NoError(err)
+	assert.Equal(WindowReceiveTime, a)
+}
@@ -3,6 +3,7 @@ package qa
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -20,3 +21,14 @@ func Root(t *testing.T, callback func(dir string)) {
 
 	callback(tmpDir)
 }
+
+// File creates test file with content in new test directory
+func File(t *testing.T, name string, content string, callback func(filename string)) {
+	Root(t, func(dir string) {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		callback(filename)
+	})
+}
@@ -0,0 +1,470 @@
+package receiver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// collectd network protocol part types
+const (
+	collectdHost           = 0x0000
+	collectdTime           = 0x0001
+	collectdPlugin         = 0x0002
+	collectdPluginInstance = 0x0003
+	collectdType           = 0x0004
+	collectdTypeInstance   = 0x0005
+	collectdValues         = 0x0006
+	collectdInterval       = 0x0007
+	collectdTimeHR         = 0x0008
+	collectdIntervalHR     = 0x0009
+	collectdEncrypt        = 0x0210
+)
+
+// collectd data source types
+const (
+	collectdCounter  = 0
+	collectdGauge    = 1
+	collectdDerive   = 2
+	collectdAbsolute = 3
+)
+
+var errBadCollectd = errors.New("bad collectd packet")
+
+const (
+	// collectdDefaultInterval is interval of values sent without interval part (seconds)
+	collectdDefaultInterval = 10
+	// collectdExpireIntervals is count of missed intervals after which rate state of data source is removed
+	collectdExpireIntervals = 5
+)
+
+// collectdValue is one data source of value list
+type collectdValue struct {
+	name      string // write_graphite name without data source
+	typ       string // type in types.db
+	dsType    byte
+	dsIndex   int
+	dsCount   int
+	value     float64
+	timestamp float64 // seconds with fraction
+	interval  float64 // seconds with fraction, 0 if unknown
+}
+
+// collectdState is state of parser between parts of packet
+type collectdState struct {
+	host           string
+	plugin         string
+	pluginInstance string
+	typ            string
+	typeInstance   string
+	time           float64
+	interval       float64
+}
+
+// collectdEscape replaces dots, spaces and control chars as write_graphite does
+func collectdEscape(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == ' ' || r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func (st *collectdState) name() string {
+	plugin := collectdEscape(st.plugin)
+	if st.pluginInstance != "" {
+		plugin += "-" + collectdEscape(st.pluginInstance)
+	}
+
+	typ := collectdEscape(st.typ)
+	if st.typeInstance != "" {
+		typ += "-" + collectdEscape(st.typeInstance)
+	}
+
+	return collectdEscape(st.host) + "." + plugin + "." + typ
+}
+
+// parseCollectd decodes packet of collectd binary protocol. Notifications are skipped
+func parseCollectd(data []byte) ([]*collectdValue, error) {
+	var result []*collectdValue
+	st := &collectdState{}
+
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errBadCollectd
+		}
+
+		partType := binary.BigEndian.Uint16(data[0:2])
+		partLen := int(binary.BigEndian.Uint16(data[2:4]))
+		if partLen < 4 || partLen > len(data) {
+			return nil, errBadCollectd
+		}
+
+		body := data[4:partLen]
+		data = data[partLen:]
+
+		switch partType {
+		case collectdHost, collectdPlugin, collectdPluginInstance, collectdType, collectdTypeInstance:
+			s := strings.TrimRight(string(body), "\x00")
+			switch partType {
+			case collectdHost:
+				st.host = s
+			case collectdPlugin:
+				st.plugin = s
+			case collectdPluginInstance:
+				st.pluginInstance = s
+			case collectdType:
+				st.typ = s
+			case collectdTypeInstance:
+				st.typeInstance = s
+			}
+		case collectdTime, collectdTimeHR:
+			if len(body) != 8 {
+				return nil, errBadCollectd
+			}
+			v := binary.BigEndian.Uint64(body)
+			if partType == collectdTime {
+				st.time = float64(v)
+			} else {
+				st.time = float64(v) / (1 << 30)
+			}
+		case collectdInterval, collectdIntervalHR:
+			if len(body) != 8 {
+				return nil, errBadCollectd
+			}
+			v := binary.BigEndian.Uint64(body)
+			if partType == collectdInterval {
+				st.interval = float64(v)
+			} else {
+				st.interval = float64(v) / (1 << 30)
+			}
+		case collectdValues:
+			values, err := st.values(body)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, values...)
+		case collectdEncrypt:
+			return nil, fmt.Errorf("encrypted collectd packets are not supported")
+		}
+	}
+
+	return result, nil
+}
+
+func (st *collectdState) values(body []byte) ([]*collectdValue, error) {
+	if len(body) < 2 {
+		return nil, errBadCollectd
+	}
+
+	count := int(binary.BigEndian.Uint16(body[0:2]))
+	body = body[2:]
+	if count == 0 || len(body) != count*9 {
+		return nil, errBadCollectd
+	}
+
+	if st.host == "" || st.plugin == "" || st.typ == "" {
+		return nil, errBadCollectd
+	}
+
+	name := st.name()
+	result := make([]*collectdValue, count)
+
+	for i := 0; i < count; i++ {
+		raw := body[count+i*8 : count+i*8+8]
+		v := &collectdValue{
+			name:      name,
+			typ:       st.typ,
+			dsType:    body[i],
+			dsIndex:   i,
+			dsCount:   count,
+			timestamp: st.time,
+			interval:  st.interval,
+		}
+
+		switch v.dsType {
+		case collectdCounter, collectdAbsolute:
+			v.value = float64(binary.BigEndian.Uint64(raw))
+		case collectdGauge:
+			// gauges are in x86 byte order
+			v.value = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+		case collectdDerive:
+			v.value = float64(int64(binary.BigEndian.Uint64(raw)))
+		default:
+			return nil, errBadCollectd
+		}
+
+		result[i] = v
+	}
+
+	return result, nil
+}
+
+// parseCollectdTypesDB reads data source names from types.db file
+func parseCollectdTypesDB(filename string) (map[string][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	types := make(map[string][]string)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(strings.Replace(line, ",", " ", -1))
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("bad line in %s: %#v", filename, line)
+		}
+
+		names := make([]string, 0, len(fields)-1)
+		for _, ds := range fields[1:] {
+			p := strings.IndexByte(ds, ':')
+			if p <= 0 {
+				return nil, fmt.Errorf("bad data source in %s: %#v", filename, line)
+			}
+			names = append(names, ds[:p])
+		}
+
+		types[fields[0]] = names
+	}
+
+	return types, scanner.Err()
+}
+
+type collectdLast struct {
+	value     float64
+	timestamp float64
+	expire    time.Time // removed if data source is not received till this time
+}
+
+// Collectd receives metrics in collectd binary protocol from UDP socket
+type Collectd struct {
+	helper.Stoppable
+	out             chan *points.Points
+	graphPrefix     string
+	prefix          string // prefix of received metrics
+	types           map[string][]string
+	rate            bool                     // convert DERIVE, COUNTER and ABSOLUTE to rates
+	last            map[string]*collectdLast // previous values of rates. Used by receive worker only
+	metricsReceived uint32
+	errors          uint32
+	metricInterval  time.Duration
+	conn            *net.UDPConn
+}
+
+// NewCollectd create new instance of Collectd
+func NewCollectd(out chan *points.Points) *Collectd {
+	return &Collectd{
+		out:            out,
+		types:          make(map[string][]string),
+		last:           make(map[string]*collectdLast),
+		metricInterval: time.Minute,
+	}
+}
+
+// SetGraphPrefix for internal cache metrics
+func (rcv *Collectd) SetGraphPrefix(prefix string) {
+	rcv.graphPrefix = prefix
+}
+
+// SetMetricInterval sets doChekpoint interval
+func (rcv *Collectd) SetMetricInterval(interval time.Duration) {
+	rcv.metricInterval = interval
+}
+
+// SetPrefix sets prefix of received metrics
+func (rcv *Collectd) SetPrefix(prefix string) {
+	rcv.prefix = prefix
+}
+
+// SetRate enables conversion of DERIVE, COUNTER and ABSOLUTE values to per second rates as StoreRates of write_graphite
+func (rcv *Collectd) SetRate(rate bool) {
+	rcv.rate = rate
+}
+
+// SetTypesDB reads names of data sources from types.db. Without it data sources
+// of multi-value types are named by index
+func (rcv *Collectd) SetTypesDB(filename string) error {
+	types, err := parseCollectdTypesDB(filename)
+	if err != nil {
+		return err
+	}
+	rcv.types = types
+	return nil
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (rcv *Collectd) Addr() net.Addr {
+	if rcv.conn == nil {
+		return nil
+	}
+	return rcv.conn.LocalAddr()
+}
+
+// Stat sends internal statistics to cache
+func (rcv *Collectd) Stat(metric string, value float64) {
+	rcv.out <- points.OnePoint(
+		fmt.Sprintf("%s%s", rcv.graphPrefix, metric),
+		value,
+		time.Now().Unix(),
+	)
+}
+
+// convert returns point of data source. Returns nil for first value of rate
+func (rcv *Collectd) convert(v *collectdValue) *points.Points {
+	name := rcv.prefix + v.name
+
+	// write_graphite appends data source name only for multi-value types
+	if v.dsCount > 1 {
+		if names := rcv.types[v.typ]; len(names) == v.dsCount {
+			name += "." + collectdEscape(names[v.dsIndex])
+		} else {
+			name += fmt.Sprintf(".%d", v.dsIndex)
+		}
+	}
+
+	value := v.value
+
+	if rcv.rate && v.dsType != collectdGauge {
+		interval := v.interval
+		if interval <= 0 {
+			interval = collectdDefaultInterval
+		}
+
+		prev := rcv.last[name]
+		rcv.last[name] = &collectdLast{
+			value:     v.value,
+			timestamp: v.timestamp,
+			expire:    time.Now().Add(time.Duration(interval * collectdExpireIntervals * float64(time.Second))),
+		}
+
+		if prev == nil || v.timestamp <= prev.timestamp {
+			return nil
+		}
+
+		switch v.dsType {
+		case collectdAbsolute:
+			// absolute value is reset on each read
+			value = v.value / (v.timestamp - prev.timestamp)
+		case collectdCounter:
+			if v.value < prev.value {
+				// counter wrapped or reset
+				return nil
+			}
+			fallthrough
+		default:
+			value = (v.value - prev.value) / (v.timestamp - prev.timestamp)
+		}
+	}
+
+	return points.OnePoint(name, value, int64(v.timestamp))
+}
+
+// expireLast removes rate state of data sources not received for few intervals
+func (rcv *Collectd) expireLast(now time.Time) {
+	for name, last := range rcv.last {
+		if now.After(last.expire) {
+			delete(rcv.last, name)
+		}
+	}
+}
+
+func (rcv *Collectd) statWorker(exit chan bool) {
+	ticker := time.NewTicker(rcv.metricInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			metricsReceived := atomic.LoadUint32(&rcv.metricsReceived)
+			atomic.AddUint32(&rcv.metricsReceived, -metricsReceived)
+			rcv.Stat("metricsReceived", float64(metricsReceived))
+
+			errors := atomic.LoadUint32(&rcv.errors)
+			atomic.AddUint32(&rcv.errors, -errors)
+			rcv.Stat("errors", float64(errors))
+
+			logrus.WithFields(logrus.Fields{
+				"metricsReceived": int(metricsReceived),
+				"errors":          int(errors),
+			}).Info("[collectd] doCheckpoint()")
+
+		case <-exit:
+			rcv.conn.Close()
+			return
+		}
+	}
+}
+
+func (rcv *Collectd) receiveWorker(exit chan bool) {
+	defer rcv.conn.Close()
+
+	var buf [65535]byte
+	nextExpire := time.Now().Add(rcv.metricInterval)
+
+	for {
+		if now := time.Now(); rcv.rate && now.After(nextExpire) {
+			rcv.expireLast(now)
+			nextExpire = now.Add(rcv.metricInterval)
+		}
+
+		rlen, peer, err := rcv.conn.ReadFromUDP(buf[:])
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				break
+			}
+			atomic.AddUint32(&rcv.errors, 1)
+			logrus.Error(err)
+			continue
+		}
+
+		values, err := parseCollectd(buf[:rlen])
+		if err != nil {
+			atomic.AddUint32(&rcv.errors, 1)
+			logrus.Infof("[collectd] %s from %s", err.Error(), peer.String())
+			continue
+		}
+
+		for _, v := range values {
+			if msg := rcv.convert(v); msg != nil {
+				atomic.AddUint32(&rcv.metricsReceived, 1)
+				rcv.out <- msg
+			}
+		}
+	}
+}
+
+// Listen bind port. Receive messages and send to out channel
+func (rcv *Collectd) Listen(addr *net.UDPAddr) error {
+	return rcv.StartFunc(func() error {
+		var err error
+		rcv.conn, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return err
+		}
+
+		rcv.Go(rcv.statWorker)
+		rcv.Go(rcv.receiveWorker)
+
+		return nil
+	})
+}
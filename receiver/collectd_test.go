@@ -0,0 +1,205 @@
+package receiver
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectdString(partType uint16, s string) []byte {
+	b := make([]byte, 4, 4+len(s)+1)
+	binary.BigEndian.PutUint16(b[0:2], partType)
+	binary.BigEndian.PutUint16(b[2:4], uint16(4+len(s)+1))
+	b = append(b, s...)
+	return append(b, 0)
+}
+
+func collectdNumber(partType uint16, v uint64) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:2], partType)
+	binary.BigEndian.PutUint16(b[2:4], 12)
+	binary.BigEndian.PutUint64(b[4:], v)
+	return b
+}
+
+func collectdValuesPart(types []byte, values []uint64) []byte {
+	n := len(types)
+	b := make([]byte, 6+n*9)
+	binary.BigEndian.PutUint16(b[0:2], collectdValues)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	binary.BigEndian.PutUint16(b[4:6], uint16(n))
+	copy(b[6:], types)
+	for i, v := range values {
+		if types[i] == collectdGauge {
+			binary.LittleEndian.PutUint64(b[6+n+i*8:], v)
+		} else {
+			binary.BigEndian.PutUint64(b[6+n+i*8:], v)
+		}
+	}
+	return b
+}
+
+func collectdPacket(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestParseCollectd(t *testing.T) {
+	assert := assert.New(t)
+
+	packet := collectdPacket(
+		collectdString(collectdHost, "web01.example.com"),
+		collectdNumber(collectdTimeHR, 1422698155<<30),
+		collectdNumber(collectdIntervalHR, 10<<30),
+		collectdString(collectdPlugin, "load"),
+		collectdString(collectdPluginInstance, ""),
+		collectdString(collectdType, "load"),
+		collectdValuesPart(
+			[]byte{collectdGauge, collectdGauge, collectdGauge},
+			[]uint64{math.Float64bits(0.5), math.Float64bits(1), math.Float64bits(1.5)},
+		),
+		collectdString(collectdPlugin, "interface"),
+		collectdString(collectdPluginInstance, "eth0"),
+		collectdString(collectdType, "if_octets"),
+		collectdNumber(collectdTime, 1422698165),
+		collectdValuesPart([]byte{collectdDerive}, []uint64{100}),
+	)
+
+	values, err := parseCollectd(packet)
+	assert.NoError(err)
+	if assert.Len(values, 4) {
+		assert.Equal(&collectdValue{
+			name:      "web01_example_com.load.load",
+			typ:       "load",
+			dsType:    collectdGauge,
+			dsIndex:   1,
+			dsCount:   3,
+			value:     1,
+			timestamp: 1422698155,
+			interval:  10,
+		}, values[1])
+		assert.Equal(&collectdValue{
+			name:      "web01_example_com.interface-eth0.if_octets",
+			typ:       "if_octets",
+			dsType:    collectdDerive,
+			dsIndex:   0,
+			dsCount:   1,
+			value:     100,
+			timestamp: 1422698165,
+			interval:  10,
+		}, values[3])
+	}
+
+	bad := [][]byte{
+		{0, 0, 0},
+		{0, 0, 0, 2},
+		{0, 0, 0, 10, 'a'},
+		collectdPacket(collectdValuesPart([]byte{collectdGauge}, []uint64{0})),
+		collectdPacket(
+			collectdString(collectdHost, "a"),
+			collectdString(collectdPlugin, "b"),
+			collectdString(collectdType, "c"),
+			collectdValuesPart([]byte{7}, []uint64{0}),
+		),
+	}
+
+	for _, b := range bad {
+		_, err := parseCollectd(b)
+		assert.Error(err)
+	}
+}
+
+func TestCollectdConvert(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "go-carbon")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	typesDB := filepath.Join(tmpDir, "types.db")
+	assert.NoError(ioutil.WriteFile(typesDB, []byte(
+		"# comment\nload shortterm:GAUGE:0:5000, midterm:GAUGE:0:5000, longterm:GAUGE:0:5000\n",
+	), 0644))
+
+	rcv := NewCollectd(nil)
+	rcv.SetPrefix("collectd.")
+	assert.NoError(rcv.SetTypesDB(typesDB))
+
+	v := &collectdValue{name: "h.load.load", typ: "load", dsType: collectdGauge, dsIndex: 1, dsCount: 3, value: 1, timestamp: 100}
+	assert.Equal(points.OnePoint("collectd.h.load.load.midterm", 1, 100), rcv.convert(v))
+
+	v = &collectdValue{name: "h.cpu.cpu", typ: "cpu", dsType: collectdGauge, dsIndex: 1, dsCount: 2, value: 1, timestamp: 100}
+	assert.Equal(points.OnePoint("collectd.h.cpu.cpu.1", 1, 100), rcv.convert(v))
+
+	derive := func(value float64, timestamp float64) *points.Points {
+		return rcv.convert(&collectdValue{name: "h.if.if_octets", typ: "if_octets", dsType: collectdDerive, dsCount: 1, value: value, timestamp: timestamp})
+	}
+
+	assert.Equal(points.OnePoint("collectd.h.if.if_octets", 100, 100), derive(100, 100))
+
+	rcv.SetRate(true)
+	assert.Nil(derive(100, 100))
+	assert.Equal(points.OnePoint("collectd.h.if.if_octets", 5, 110), derive(150, 110))
+	assert.Equal(points.OnePoint("collectd.h.if.if_octets", -1, 120), derive(140, 120))
+
+	absolute := func(value float64, timestamp float64) *points.Points {
+		return rcv.convert(&collectdValue{name: "h.a.absolute", typ: "absolute", dsType: collectdAbsolute, dsCount: 1, value: value, timestamp: timestamp, interval: 10})
+	}
+	assert.Nil(absolute(100, 100))
+	assert.Equal(points.OnePoint("collectd.h.a.absolute", 3, 110), absolute(30, 110))
+
+	// rate state of data sources not received for few intervals is removed
+	rcv.expireLast(time.Now())
+	assert.Len(rcv.last, 2)
+	rcv.expireLast(time.Now().Add(collectdExpireIntervals * collectdDefaultInterval * time.Second))
+	assert.Len(rcv.last, 0)
+}
+
+func TestCollectd(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcvChan := make(chan *points.Points, 128)
+	receiver := NewCollectd(rcvChan)
+	if err = receiver.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Stop()
+
+	conn, err := net.Dial("udp", receiver.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write(collectdPacket(
+		collectdString(collectdHost, "web01"),
+		collectdNumber(collectdTime, 1422698155),
+		collectdString(collectdPlugin, "memory"),
+		collectdString(collectdType, "memory"),
+		collectdString(collectdTypeInstance, "free"),
+		collectdValuesPart([]byte{collectdGauge}, []uint64{math.Float64bits(42)}),
+	))
+
+	select {
+	case msg := <-rcvChan:
+		if !msg.Eq(points.OnePoint("web01.memory.memory-free", 42, 1422698155)) {
+			t.Fatalf("%#v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message #0 not received")
+	}
+}
@@ -0,0 +1,46 @@
+package receiver
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMaxWindow limits memory allocated by zstd decoder of one connection
+const zstdMaxWindow = 8 << 20 // 8 Mb
+
+// checkCompression validates value of "compression" option
+func checkCompression(compression string) error {
+	switch compression {
+	case "", "none", "gzip", "snappy", "zstd":
+		return nil
+	}
+	return fmt.Errorf("unknown compression %#v", compression)
+}
+
+// decompress wraps stream with decompressor. Snappy stream should be in framing format
+func decompress(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case "", "none":
+		return ioutil.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "snappy":
+		return ioutil.NopCloser(snappy.NewReader(r)), nil
+	case "zstd":
+		d, err := zstd.NewReader(r,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(zstdMaxWindow),
+			zstd.WithDecoderMaxMemory(zstdMaxWindow),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown compression %#v", compression)
+}
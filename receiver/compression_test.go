@@ -0,0 +1,39 @@
+package receiver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZstdMaxWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	zstdStream := func(size int, window int) []byte {
+		buf := new(bytes.Buffer)
+		w, err := zstd.NewWriter(buf, zstd.WithWindowSize(window))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte("hello.world 42 1422698155\n"), size/26))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	r, err := decompress("zstd", bytes.NewReader(zstdStream(1<<20, 1<<20)))
+	assert.NoError(err)
+	data, err := ioutil.ReadAll(r)
+	assert.NoError(err)
+	assert.Len(data, (1<<20)/26*26)
+	r.Close()
+
+	// frame with window over limit is rejected
+	r, err = decompress("zstd", bytes.NewReader(zstdStream(16<<20, 32<<20)))
+	assert.NoError(err)
+	_, err = ioutil.ReadAll(r)
+	assert.Error(err)
+	r.Close()
+}
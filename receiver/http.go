@@ -0,0 +1,255 @@
+package receiver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/tags"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// HTTP receive metrics from POST requests. Body is plain text protocol or json array
+// of {"metric": "...", "value": 42, "timestamp": 1422698155} objects
+type HTTP struct {
+	helper.Stoppable
+	out             chan *points.Points
+	graphPrefix     string
+	metricsReceived uint32
+	errors          uint32
+	requests        uint32
+	maxBodySize     int64
+	metricInterval  time.Duration
+	listener        *net.TCPListener
+}
+
+// NewHTTP create new instance of HTTP
+func NewHTTP(out chan *points.Points) *HTTP {
+	return &HTTP{
+		out:            out,
+		metricInterval: time.Minute,
+		maxBodySize:    67108864, // 64 Mb
+	}
+}
+
+// SetGraphPrefix for internal cache metrics
+func (rcv *HTTP) SetGraphPrefix(prefix string) {
+	rcv.graphPrefix = prefix
+}
+
+// SetMetricInterval sets doChekpoint interval
+func (rcv *HTTP) SetMetricInterval(interval time.Duration) {
+	rcv.metricInterval = interval
+}
+
+// SetMaxBodySize sets limit of request body (in bytes)
+func (rcv *HTTP) SetMaxBodySize(size int64) {
+	rcv.maxBodySize = size
+}
+
+// Stat sends internal statistics to cache
+func (rcv *HTTP) Stat(metric string, value float64) {
+	rcv.out <- points.OnePoint(
+		fmt.Sprintf("%s%s", rcv.graphPrefix, metric),
+		value,
+		time.Now().Unix(),
+	)
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (rcv *HTTP) Addr() net.Addr {
+	if rcv.listener == nil {
+		return nil
+	}
+	return rcv.listener.Addr()
+}
+
+type httpMetric struct {
+	Metric    string          `json:"metric"`
+	Value     *float64        `json:"value"`
+	Timestamp json.RawMessage `json:"timestamp"` // decoded per point, bad timestamp rejects only its point
+}
+
+type httpReply struct {
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+// textName returns false if metric name can't be written as one line of text protocol,
+// which is used by journal and dump
+func textName(name string) bool {
+	return strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r == 0x7f }) < 0
+}
+
+// parseJSON parses json body. Points without timestamp are received now, fractional timestamps are truncated.
+// Names with whitespace or control chars are rejected
+func parseJSON(body io.Reader) ([]*points.Points, int, error) {
+	var list []httpMetric
+
+	if err := json.NewDecoder(body).Decode(&list); err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]*points.Points, 0, len(list))
+	rejected := 0
+	now := time.Now().Unix()
+
+	for _, m := range list {
+		if m.Value == nil || math.IsNaN(*m.Value) {
+			rejected++
+			continue
+		}
+
+		name, err := tags.Normalize(m.Metric)
+		if err != nil || name == "" || !textName(name) {
+			rejected++
+			continue
+		}
+
+		timestamp := now
+		if len(m.Timestamp) > 0 && string(m.Timestamp) != "null" {
+			var ts float64
+			if err := json.Unmarshal(m.Timestamp, &ts); err != nil || math.IsNaN(ts) {
+				rejected++
+				continue
+			}
+			if ts != 0 {
+				timestamp = int64(ts)
+			}
+		}
+
+		result = append(result, points.OnePoint(name, *m.Value, timestamp))
+	}
+
+	return result, rejected, nil
+}
+
+// parseLines parses body in plain text protocol
+func parseLines(body io.Reader) ([]*points.Points, int, error) {
+	result := make([]*points.Points, 0)
+	rejected := 0
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		msg, err := points.ParseText(line)
+		if err != nil {
+			logrus.Debugf("[http] %s", err.Error())
+			rejected++
+			continue
+		}
+		result = append(result, msg)
+	}
+
+	return result, rejected, scanner.Err()
+}
+
+func (rcv *HTTP) reply(w http.ResponseWriter, status int, reply *httpReply) {
+	body, _ := json.Marshal(reply)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// ServeHTTP receives POST request
+func (rcv *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddUint32(&rcv.requests, 1)
+
+	if r.Method != "POST" {
+		rcv.reply(w, http.StatusMethodNotAllowed, &httpReply{Error: "only POST is allowed"})
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, rcv.maxBodySize)
+
+	parse := parseLines
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		parse = parseJSON
+	}
+
+	msgs, rejected, err := parse(body)
+	if err != nil {
+		atomic.AddUint32(&rcv.errors, 1)
+		rcv.reply(w, http.StatusBadRequest, &httpReply{Error: err.Error()})
+		return
+	}
+
+	for _, msg := range msgs {
+		rcv.out <- msg
+	}
+
+	atomic.AddUint32(&rcv.metricsReceived, uint32(len(msgs)))
+	atomic.AddUint32(&rcv.errors, uint32(rejected))
+
+	rcv.reply(w, http.StatusOK, &httpReply{Accepted: len(msgs), Rejected: rejected})
+}
+
+// Listen bind port. Receive messages and send to out channel
+func (rcv *HTTP) Listen(addr *net.TCPAddr) error {
+	return rcv.StartFunc(func() error {
+		tcpListener, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		srv := &http.Server{
+			Handler:      rcv,
+			ReadTimeout:  time.Minute,
+			WriteTimeout: time.Minute,
+		}
+
+		rcv.Go(func(exit chan bool) {
+			ticker := time.NewTicker(rcv.metricInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					metricsReceived := atomic.LoadUint32(&rcv.metricsReceived)
+					atomic.AddUint32(&rcv.metricsReceived, -metricsReceived)
+					rcv.Stat("metricsReceived", float64(metricsReceived))
+
+					errors := atomic.LoadUint32(&rcv.errors)
+					atomic.AddUint32(&rcv.errors, -errors)
+					rcv.Stat("errors", float64(errors))
+
+					requests := atomic.LoadUint32(&rcv.requests)
+					atomic.AddUint32(&rcv.requests, -requests)
+					rcv.Stat("requests", float64(requests))
+
+					logrus.WithFields(logrus.Fields{
+						"metricsReceived": int(metricsReceived),
+						"errors":          int(errors),
+						"requests":        int(requests),
+					}).Info("[http] doCheckpoint()")
+				case <-exit:
+					tcpListener.Close()
+					return
+				}
+			}
+		})
+
+		rcv.Go(func(exit chan bool) {
+			srv.Serve(tcpListener)
+		})
+
+		rcv.listener = tcpListener
+
+		return nil
+	})
+}
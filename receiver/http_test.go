@@ -0,0 +1,56 @@
+package receiver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTP(t *testing.T) {
+	assert := assert.New(t)
+
+	rcvChan := make(chan *points.Points, 128)
+	rcv := NewHTTP(rcvChan)
+
+	post := func(contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		rcv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("text/plain", "hello.world 42.15 1422698155\nbad line\n\nmetric.name -72.11 1422698155\n")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"accepted":2,"rejected":1}`, w.Body.String())
+	assert.True((<-rcvChan).Eq(points.OnePoint("hello.world", 42.15, 1422698155)))
+	assert.True((<-rcvChan).Eq(points.OnePoint("metric.name", -72.11, 1422698155)))
+
+	w = post("application/json", `[
+		{"metric": "hello.world", "value": 42.15, "timestamp": 1422698155},
+		{"metric": "cpu.load;host=a;dc=x", "value": 1, "timestamp": 1422698155},
+		{"metric": "no.value", "timestamp": 1422698155},
+		{"metric": "", "value": 1},
+		{"metric": "float.timestamp", "value": 2, "timestamp": 1422698155.5},
+		{"metric": "bad.timestamp", "value": 3, "timestamp": "yesterday"},
+		{"metric": "a\nb.c 1 2", "value": 4},
+		{"metric": "with space", "value": 5},
+		{"metric": "tab\tname", "value": 6}
+	]`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"accepted":3,"rejected":6}`, w.Body.String())
+	assert.True((<-rcvChan).Eq(points.OnePoint("hello.world", 42.15, 1422698155)))
+	assert.True((<-rcvChan).Eq(points.OnePoint("cpu.load;dc=x;host=a", 1, 1422698155)))
+	assert.True((<-rcvChan).Eq(points.OnePoint("float.timestamp", 2, 1422698155)))
+
+	w = post("application/json", `{"metric":`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	rcv.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,581 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/tags"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var influxPlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// influxLine is one parsed line of influxdb line protocol
+type influxLine struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]float64
+	timestamp   int64 // in nanoseconds, 0 if not set
+}
+
+// influxSplit splits s by sep outside of double quotes. Backslash escapes next char
+func influxSplit(s string, sep byte) []string {
+	var result []string
+
+	quoted := false
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(result, s[start:])
+}
+
+// influxUnescape removes backslashes before escaped chars
+func influxUnescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// parseInfluxValue parses field value. Strings are skipped (ok = false)
+func parseInfluxValue(value string) (float64, bool, error) {
+	if value == "" {
+		return 0, false, fmt.Errorf("empty field value")
+	}
+
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	if value[0] == '"' {
+		return 0, false, nil
+	}
+
+	last := value[len(value)-1]
+	if last == 'i' || last == 'u' {
+		v, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		if err != nil {
+			return 0, false, err
+		}
+		return float64(v), true, nil
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false, fmt.Errorf("bad field value %#v", value)
+	}
+	return v, true, nil
+}
+
+// parseInflux parses one line: measurement[,tag=value...] field=value[,field=value...] [timestamp]
+func parseInflux(line string) (*influxLine, error) {
+	parts := influxSplit(strings.TrimSpace(line), ' ')
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("bad influx message: %#v", line)
+	}
+
+	m := &influxLine{
+		tags:   make(map[string]string),
+		fields: make(map[string]float64),
+	}
+
+	key := influxSplit(parts[0], ',')
+	m.measurement = influxUnescape(key[0])
+	if m.measurement == "" {
+		return nil, fmt.Errorf("empty measurement: %#v", line)
+	}
+
+	for _, tag := range key[1:] {
+		kv := influxSplit(tag, '=')
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("bad influx tag %#v: %#v", tag, line)
+		}
+		m.tags[influxUnescape(kv[0])] = influxUnescape(kv[1])
+	}
+
+	for _, field := range influxSplit(parts[1], ',') {
+		kv := influxSplit(field, '=')
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("bad influx field %#v: %#v", field, line)
+		}
+		value, ok, err := parseInfluxValue(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad influx field %#v: %#v", field, line)
+		}
+		if ok {
+			m.fields[influxUnescape(kv[0])] = value
+		}
+	}
+
+	if len(parts) == 3 {
+		ts, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad influx timestamp: %#v", line)
+		}
+		m.timestamp = ts
+	}
+
+	return m, nil
+}
+
+// influxPrecision returns number of nanoseconds in timestamp unit
+func influxPrecision(precision string) (int64, error) {
+	switch precision {
+	case "", "n", "ns":
+		return 1, nil
+	case "u", "us":
+		return int64(time.Microsecond), nil
+	case "ms":
+		return int64(time.Millisecond), nil
+	case "s":
+		return int64(time.Second), nil
+	case "m":
+		return int64(time.Minute), nil
+	case "h":
+		return int64(time.Hour), nil
+	}
+	return 0, fmt.Errorf("unknown precision %#v", precision)
+}
+
+// Influx receives metrics in influxdb line protocol over tcp, udp and http
+type Influx struct {
+	helper.Stoppable
+	out             chan *points.Points
+	graphPrefix     string
+	template        string
+	tagged          bool
+	metricsReceived uint32
+	errors          uint32
+	active          int32 // counter of active tcp connections
+	maxBodySize     int64
+	metricInterval  time.Duration
+	tcpListener     *net.TCPListener
+	udpConn         *net.UDPConn
+	httpListener    *net.TCPListener
+}
+
+// NewInflux create new instance of Influx
+func NewInflux(out chan *points.Points) *Influx {
+	return &Influx{
+		out:            out,
+		template:       "{measurement}.{field}",
+		metricInterval: time.Minute,
+		maxBodySize:    67108864, // 64 Mb
+	}
+}
+
+// SetGraphPrefix for internal cache metrics
+func (rcv *Influx) SetGraphPrefix(prefix string) {
+	rcv.graphPrefix = prefix
+}
+
+// SetMetricInterval sets doChekpoint interval
+func (rcv *Influx) SetMetricInterval(interval time.Duration) {
+	rcv.metricInterval = interval
+}
+
+// SetMaxBodySize sets limit of http request body (in bytes)
+func (rcv *Influx) SetMaxBodySize(size int64) {
+	rcv.maxBodySize = size
+}
+
+// SetTemplate sets template of graphite name. Known placeholders are {measurement}, {field} and {tag.NAME}
+func (rcv *Influx) SetTemplate(template string) error {
+	for _, m := range influxPlaceholder.FindAllStringSubmatch(template, -1) {
+		if m[1] != "measurement" && m[1] != "field" && !strings.HasPrefix(m[1], "tag.") {
+			return fmt.Errorf("unknown placeholder %#v in template %#v", m[0], template)
+		}
+	}
+	rcv.template = template
+	return nil
+}
+
+// SetTagged enables sending of tagged series "measurement.field;tag=value" instead of template
+func (rcv *Influx) SetTagged(tagged bool) {
+	rcv.tagged = tagged
+}
+
+// Stat sends internal statistics to cache
+func (rcv *Influx) Stat(metric string, value float64) {
+	rcv.out <- points.OnePoint(
+		fmt.Sprintf("%s%s", rcv.graphPrefix, metric),
+		value,
+		time.Now().Unix(),
+	)
+}
+
+// TCPAddr returns binded tcp socket address. For bind port 0 in tests
+func (rcv *Influx) TCPAddr() net.Addr {
+	if rcv.tcpListener == nil {
+		return nil
+	}
+	return rcv.tcpListener.Addr()
+}
+
+// UDPAddr returns binded udp socket address. For bind port 0 in tests
+func (rcv *Influx) UDPAddr() net.Addr {
+	if rcv.udpConn == nil {
+		return nil
+	}
+	return rcv.udpConn.LocalAddr()
+}
+
+// HTTPAddr returns binded http socket address. For bind port 0 in tests
+func (rcv *Influx) HTTPAddr() net.Addr {
+	if rcv.httpListener == nil {
+		return nil
+	}
+	return rcv.httpListener.Addr()
+}
+
+// name returns graphite name of field by template. Empty components are removed
+func (rcv *Influx) name(m *influxLine, field string) string {
+	if rcv.tagged {
+		list := make([]string, 0, len(m.tags))
+		for k, v := range m.tags {
+			list = append(list, k+"="+v)
+		}
+		sort.Strings(list)
+		return strings.Join(append([]string{m.measurement + "." + field}, list...), ";")
+	}
+
+	name := influxPlaceholder.ReplaceAllStringFunc(rcv.template, func(p string) string {
+		key := p[1 : len(p)-1]
+		switch key {
+		case "measurement":
+			return m.measurement
+		case "field":
+			return field
+		}
+		return m.tags[strings.TrimPrefix(key, "tag.")]
+	})
+
+	parts := strings.Split(strings.Replace(name, " ", "_", -1), ".")
+	result := parts[:0]
+	for _, p := range parts {
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	return strings.Join(result, ".")
+}
+
+// convert parses line and returns one message per numeric field
+func (rcv *Influx) convert(line string, precision int64, now time.Time) ([]*points.Points, error) {
+	m, err := parseInflux(line)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := now.Unix()
+	if m.timestamp != 0 {
+		if precision >= int64(time.Second) {
+			timestamp = m.timestamp * (precision / int64(time.Second))
+		} else {
+			timestamp = m.timestamp / (int64(time.Second) / precision)
+		}
+	}
+
+	result := make([]*points.Points, 0, len(m.fields))
+	for field, value := range m.fields {
+		name, err := tags.Normalize(rcv.name(m, field))
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty metric name: %#v", line)
+		}
+		result = append(result, points.OnePoint(name, value, timestamp))
+	}
+
+	return result, nil
+}
+
+// parseBody parses lines from reader and returns count of bad lines
+func (rcv *Influx) parseBody(body io.Reader, precision int64) ([]*points.Points, int, error) {
+	result := make([]*points.Points, 0)
+	rejected := 0
+	now := time.Now()
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		msgs, err := rcv.convert(line, precision, now)
+		if err != nil {
+			logrus.Debugf("[influx] %s", err.Error())
+			rejected++
+			continue
+		}
+		result = append(result, msgs...)
+	}
+
+	return result, rejected, scanner.Err()
+}
+
+func (rcv *Influx) send(msgs []*points.Points, rejected int) {
+	for _, msg := range msgs {
+		rcv.out <- msg
+	}
+	atomic.AddUint32(&rcv.metricsReceived, uint32(len(msgs)))
+	atomic.AddUint32(&rcv.errors, uint32(rejected))
+}
+
+func (rcv *Influx) handleConnection(conn net.Conn) {
+	atomic.AddInt32(&rcv.active, 1)
+	defer atomic.AddInt32(&rcv.active, -1)
+
+	defer conn.Close()
+
+	finished := make(chan bool)
+	defer close(finished)
+
+	rcv.Go(func(exit chan bool) {
+		select {
+		case <-finished:
+			return
+		case <-exit:
+			conn.Close()
+			return
+		}
+	})
+
+	reader := bufio.NewReader(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Minute))
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				if len(line) > 0 {
+					logrus.Warningf("[influx] Unfinished line: %#v", line)
+				}
+			} else {
+				atomic.AddUint32(&rcv.errors, 1)
+				logrus.Error(err)
+			}
+			break
+		}
+
+		msgs, rejected, _ := rcv.parseBody(strings.NewReader(line), 1)
+		rcv.send(msgs, rejected)
+	}
+}
+
+func (rcv *Influx) receiveWorker(exit chan bool) {
+	defer rcv.udpConn.Close()
+
+	var buf [65535]byte
+
+	for {
+		rlen, _, err := rcv.udpConn.ReadFromUDP(buf[:])
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				break
+			}
+			atomic.AddUint32(&rcv.errors, 1)
+			logrus.Error(err)
+			continue
+		}
+
+		msgs, rejected, _ := rcv.parseBody(bytes.NewReader(buf[:rlen]), 1)
+		rcv.send(msgs, rejected)
+	}
+}
+
+// ServeHTTP receives POST /write requests as influxdb does
+func (rcv *Influx) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(status int, err error) {
+		atomic.AddUint32(&rcv.errors, 1)
+		body, _ := json.Marshal(map[string]string{"error": err.Error()})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+
+	if r.Method != "POST" {
+		reply(http.StatusMethodNotAllowed, fmt.Errorf("only POST is allowed"))
+		return
+	}
+
+	precision, err := influxPrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		reply(http.StatusBadRequest, err)
+		return
+	}
+
+	msgs, rejected, err := rcv.parseBody(http.MaxBytesReader(w, r.Body, rcv.maxBodySize), precision)
+	if err != nil {
+		reply(http.StatusBadRequest, err)
+		return
+	}
+
+	rcv.send(msgs, rejected)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rcv *Influx) statWorker(exit chan bool) {
+	ticker := time.NewTicker(rcv.metricInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			metricsReceived := atomic.LoadUint32(&rcv.metricsReceived)
+			atomic.AddUint32(&rcv.metricsReceived, -metricsReceived)
+			rcv.Stat("metricsReceived", float64(metricsReceived))
+
+			active := float64(atomic.LoadInt32(&rcv.active))
+			rcv.Stat("active", active)
+
+			errors := atomic.LoadUint32(&rcv.errors)
+			atomic.AddUint32(&rcv.errors, -errors)
+			rcv.Stat("errors", float64(errors))
+
+			logrus.WithFields(logrus.Fields{
+				"metricsReceived": int(metricsReceived),
+				"active":          int(active),
+				"errors":          int(errors),
+			}).Info("[influx] doCheckpoint()")
+
+		case <-exit:
+			if rcv.tcpListener != nil {
+				rcv.tcpListener.Close()
+			}
+			if rcv.udpConn != nil {
+				rcv.udpConn.Close()
+			}
+			if rcv.httpListener != nil {
+				rcv.httpListener.Close()
+			}
+			return
+		}
+	}
+}
+
+// Listen bind ports. Nil address disables protocol. Receive messages and send to out channel
+func (rcv *Influx) Listen(tcpAddr *net.TCPAddr, udpAddr *net.UDPAddr, httpAddr *net.TCPAddr) error {
+	return rcv.StartFunc(func() error {
+		var err error
+
+		closeAll := func() {
+			if rcv.tcpListener != nil {
+				rcv.tcpListener.Close()
+			}
+			if rcv.udpConn != nil {
+				rcv.udpConn.Close()
+			}
+		}
+
+		if tcpAddr != nil {
+			if rcv.tcpListener, err = net.ListenTCP("tcp", tcpAddr); err != nil {
+				return err
+			}
+		}
+
+		if udpAddr != nil {
+			if rcv.udpConn, err = net.ListenUDP("udp", udpAddr); err != nil {
+				closeAll()
+				return err
+			}
+		}
+
+		if httpAddr != nil {
+			if rcv.httpListener, err = net.ListenTCP("tcp", httpAddr); err != nil {
+				closeAll()
+				return err
+			}
+		}
+
+		rcv.Go(rcv.statWorker)
+
+		if rcv.tcpListener != nil {
+			rcv.Go(func(exit chan bool) {
+				defer rcv.tcpListener.Close()
+
+				for {
+					conn, err := rcv.tcpListener.Accept()
+					if err != nil {
+						if strings.Contains(err.Error(), "use of closed network connection") {
+							break
+						}
+						logrus.Warningf("[influx] Failed to accept connection: %s", err)
+						continue
+					}
+
+					rcv.Go(func(exit chan bool) {
+						rcv.handleConnection(conn)
+					})
+				}
+			})
+		}
+
+		if rcv.udpConn != nil {
+			rcv.Go(rcv.receiveWorker)
+		}
+
+		if rcv.httpListener != nil {
+			mux := http.NewServeMux()
+			mux.Handle("/write", rcv)
+
+			srv := &http.Server{
+				Handler:      mux,
+				ReadTimeout:  time.Minute,
+				WriteTimeout: time.Minute,
+			}
+
+			rcv.Go(func(exit chan bool) {
+				srv.Serve(rcv.httpListener)
+			})
+		}
+
+		return nil
+	})
+}
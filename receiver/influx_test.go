@@ -0,0 +1,139 @@
+package receiver
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInflux(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := parseInflux(`cpu,host=server\ 01,region=us-west usage=0.64,count=42i,up=t,desc="hello, world" 1422698155000000000`)
+	assert.NoError(err)
+	assert.Equal(&influxLine{
+		measurement: "cpu",
+		tags:        map[string]string{"host": "server 01", "region": "us-west"},
+		fields:      map[string]float64{"usage": 0.64, "count": 42, "up": 1},
+		timestamp:   1422698155000000000,
+	}, m)
+
+	m, err = parseInflux("mem free=1")
+	assert.NoError(err)
+	assert.Equal(int64(0), m.timestamp)
+
+	bad := []string{
+		"cpu",
+		"cpu,host usage=1",
+		"cpu usage=abc",
+		"cpu usage=1 ts",
+		",host=a usage=1",
+	}
+
+	for _, line := range bad {
+		_, err := parseInflux(line)
+		assert.Error(err, line)
+	}
+}
+
+func influxNames(msgs []*points.Points) []string {
+	var names []string
+	for _, msg := range msgs {
+		names = append(names, msg.Metric)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestInfluxConvert(t *testing.T) {
+	assert := assert.New(t)
+
+	rcv := NewInflux(nil)
+	assert.Error(rcv.SetTemplate("{host}.{field}"))
+	assert.NoError(rcv.SetTemplate("{tag.host}.{tag.dc}.{measurement}.{field}"))
+
+	now := time.Unix(1000, 0)
+
+	msgs, err := rcv.convert("cpu,host=srv1 user=1,system=2 1422698155000000000", 1, now)
+	assert.NoError(err)
+	assert.Equal([]string{"srv1.cpu.system", "srv1.cpu.user"}, influxNames(msgs))
+	assert.Equal(int64(1422698155), msgs[0].Data[0].Timestamp)
+
+	msgs, err = rcv.convert("cpu,host=srv1 user=1 1422698155", int64(time.Second), now)
+	assert.NoError(err)
+	assert.Equal(int64(1422698155), msgs[0].Data[0].Timestamp)
+
+	msgs, err = rcv.convert("cpu user=1", 1, now)
+	assert.NoError(err)
+	assert.Equal("cpu.user", msgs[0].Metric)
+	assert.Equal(int64(1000), msgs[0].Data[0].Timestamp)
+
+	rcv.SetTagged(true)
+	msgs, err = rcv.convert("cpu,host=srv1,dc=eu user=1", 1, now)
+	assert.NoError(err)
+	assert.Equal("cpu.user;dc=eu;host=srv1", msgs[0].Metric)
+}
+
+func TestInflux(t *testing.T) {
+	assert := assert.New(t)
+
+	tcpAddr, _ := net.ResolveTCPAddr("tcp", "localhost:0")
+	udpAddr, _ := net.ResolveUDPAddr("udp", "localhost:0")
+	httpAddr, _ := net.ResolveTCPAddr("tcp", "localhost:0")
+
+	rcvChan := make(chan *points.Points, 128)
+	rcv := NewInflux(rcvChan)
+	if err := rcv.Listen(tcpAddr, udpAddr, httpAddr); err != nil {
+		t.Fatal(err)
+	}
+	defer rcv.Stop()
+
+	receive := func(metric string) {
+		select {
+		case msg := <-rcvChan:
+			assert.Equal(metric, msg.Metric)
+			assert.Equal(int64(1422698155), msg.Data[0].Timestamp)
+		case <-time.After(time.Second):
+			t.Fatalf("%s not received", metric)
+		}
+	}
+
+	conn, err := net.Dial("tcp", rcv.TCPAddr().String())
+	assert.NoError(err)
+	conn.Write([]byte("tcp value=1 1422698155000000000\n"))
+	conn.Close()
+	receive("tcp.value")
+
+	conn, err = net.Dial("udp", rcv.UDPAddr().String())
+	assert.NoError(err)
+	conn.Write([]byte("udp value=1 1422698155000000000\n"))
+	conn.Close()
+	receive("udp.value")
+
+	resp, err := http.Post(
+		"http://"+rcv.HTTPAddr().String()+"/write?precision=s",
+		"text/plain",
+		bytes.NewBufferString("http value=1 1422698155\n"),
+	)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	receive("http.value")
+
+	rcv.SetMaxBodySize(16)
+	resp, err = http.Post(
+		"http://"+rcv.HTTPAddr().String()+"/write?precision=s",
+		"text/plain",
+		bytes.NewBufferString("http value=1 1422698155\nhttp value=2 1422698155\n"),
+	)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	assert.Len(rcvChan, 0)
+}
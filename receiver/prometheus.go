@@ -0,0 +1,200 @@
+package receiver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Prometheus receives snappy compressed WriteRequest messages of prometheus remote_write
+type Prometheus struct {
+	helper.Stoppable
+	out             chan *points.Points
+	graphPrefix     string
+	tagged          bool
+	metricsReceived uint32
+	seriesSkipped   uint32
+	errors          uint32
+	requests        uint32
+	maxBodySize     int64
+	maxDecodedSize  int
+	metricInterval  time.Duration
+	listener        *net.TCPListener
+}
+
+// NewPrometheus create new instance of Prometheus
+func NewPrometheus(out chan *points.Points) *Prometheus {
+	return &Prometheus{
+		out:            out,
+		metricInterval: time.Minute,
+		maxBodySize:    67108864,  // 64 Mb
+		maxDecodedSize: 268435456, // 256 Mb
+	}
+}
+
+// SetGraphPrefix for internal cache metrics
+func (rcv *Prometheus) SetGraphPrefix(prefix string) {
+	rcv.graphPrefix = prefix
+}
+
+// SetMetricInterval sets doChekpoint interval
+func (rcv *Prometheus) SetMetricInterval(interval time.Duration) {
+	rcv.metricInterval = interval
+}
+
+// SetMaxBodySize sets limit of compressed request body (in bytes)
+func (rcv *Prometheus) SetMaxBodySize(size int64) {
+	rcv.maxBodySize = size
+}
+
+// SetMaxDecodedSize sets limit of decompressed request body (in bytes)
+func (rcv *Prometheus) SetMaxDecodedSize(size int) {
+	rcv.maxDecodedSize = size
+}
+
+// SetTagged enables sending of tagged series "name;label=value" instead of "name.label.value"
+func (rcv *Prometheus) SetTagged(tagged bool) {
+	rcv.tagged = tagged
+}
+
+// Stat sends internal statistics to cache
+func (rcv *Prometheus) Stat(metric string, value float64) {
+	rcv.out <- points.OnePoint(
+		fmt.Sprintf("%s%s", rcv.graphPrefix, metric),
+		value,
+		time.Now().Unix(),
+	)
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (rcv *Prometheus) Addr() net.Addr {
+	if rcv.listener == nil {
+		return nil
+	}
+	return rcv.listener.Addr()
+}
+
+// ServeHTTP receives POST request
+func (rcv *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddUint32(&rcv.requests, 1)
+
+	fail := func(status int, err error) {
+		atomic.AddUint32(&rcv.errors, 1)
+		logrus.Debugf("[prometheus] %s", err.Error())
+		http.Error(w, err.Error(), status)
+	}
+
+	if r.Method != "POST" {
+		fail(http.StatusMethodNotAllowed, fmt.Errorf("only POST is allowed"))
+		return
+	}
+
+	compressed, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, rcv.maxBodySize))
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	// snappy.Decode allocates size from header before decoding
+	decodedSize, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	if decodedSize > rcv.maxDecodedSize {
+		fail(http.StatusRequestEntityTooLarge, fmt.Errorf("decoded body size %d is over limit %d", decodedSize, rcv.maxDecodedSize))
+		return
+	}
+
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	msgs, skipped, err := points.ParsePrometheusWriteRequest(body, rcv.tagged)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	atomic.AddUint32(&rcv.seriesSkipped, uint32(skipped))
+
+	cnt := 0
+	for _, msg := range msgs {
+		cnt += len(msg.Data)
+		rcv.out <- msg
+	}
+	atomic.AddUint32(&rcv.metricsReceived, uint32(cnt))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Listen bind port. Receive messages and send to out channel
+func (rcv *Prometheus) Listen(addr *net.TCPAddr) error {
+	return rcv.StartFunc(func() error {
+		tcpListener, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		srv := &http.Server{
+			Handler:      rcv,
+			ReadTimeout:  time.Minute,
+			WriteTimeout: time.Minute,
+		}
+
+		rcv.Go(func(exit chan bool) {
+			ticker := time.NewTicker(rcv.metricInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					metricsReceived := atomic.LoadUint32(&rcv.metricsReceived)
+					atomic.AddUint32(&rcv.metricsReceived, -metricsReceived)
+					rcv.Stat("metricsReceived", float64(metricsReceived))
+
+					seriesSkipped := atomic.LoadUint32(&rcv.seriesSkipped)
+					atomic.AddUint32(&rcv.seriesSkipped, -seriesSkipped)
+					rcv.Stat("seriesSkipped", float64(seriesSkipped))
+
+					errors := atomic.LoadUint32(&rcv.errors)
+					atomic.AddUint32(&rcv.errors, -errors)
+					rcv.Stat("errors", float64(errors))
+
+					requests := atomic.LoadUint32(&rcv.requests)
+					atomic.AddUint32(&rcv.requests, -requests)
+					rcv.Stat("requests", float64(requests))
+
+					logrus.WithFields(logrus.Fields{
+						"metricsReceived": int(metricsReceived),
+						"seriesSkipped":   int(seriesSkipped),
+						"errors":          int(errors),
+						"requests":        int(requests),
+					}).Info("[prometheus] doCheckpoint()")
+				case <-exit:
+					tcpListener.Close()
+					return
+				}
+			}
+		})
+
+		rcv.Go(func(exit chan bool) {
+			srv.Serve(tcpListener)
+		})
+
+		rcv.listener = tcpListener
+
+		return nil
+	})
+}
@@ -0,0 +1,78 @@
+package receiver
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func prometheusWriteRequest(name string, value float64, ms int64) []byte {
+	var label, sample, series, req []byte
+
+	label = protowire.AppendTag(label, 1, protowire.BytesType)
+	label = protowire.AppendString(label, "__name__")
+	label = protowire.AppendTag(label, 2, protowire.BytesType)
+	label = protowire.AppendString(label, name)
+
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(ms))
+
+	series = protowire.AppendTag(series, 1, protowire.BytesType)
+	series = protowire.AppendBytes(series, label)
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample)
+
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, series)
+
+	return req
+}
+
+func TestPrometheus(t *testing.T) {
+	assert := assert.New(t)
+
+	rcvChan := make(chan *points.Points, 128)
+	rcv := NewPrometheus(rcvChan)
+
+	post := func(body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/write", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		w := httptest.NewRecorder()
+		rcv.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(snappy.Encode(nil, prometheusWriteRequest("up", 1, 1422698155123)))
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.True((<-rcvChan).Eq(points.OnePoint("up", 1, 1422698155)))
+
+	// not compressed
+	w = post(prometheusWriteRequest("up", 1, 1422698155123))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = post(snappy.Encode(nil, []byte{0x0a, 0xff}))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	rcv.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	// header of few bytes declares 4 Gb body
+	w = post([]byte{0xff, 0xff, 0xff, 0xff, 0x0f, 0x00})
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code)
+
+	rcv.SetMaxDecodedSize(8)
+	w = post(snappy.Encode(nil, prometheusWriteRequest("up", 1, 1422698155123)))
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code)
+}
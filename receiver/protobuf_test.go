@@ -0,0 +1,63 @@
+package receiver
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lomik/go-carbon/points"
+)
+
+func TestProtobuf(t *testing.T) {
+	var point, metric, payload []byte
+
+	point = protowire.AppendTag(point, 1, protowire.VarintType)
+	point = protowire.AppendVarint(point, 1452200952)
+	point = protowire.AppendTag(point, 2, protowire.Fixed64Type)
+	point = protowire.AppendFixed64(point, math.Float64bits(42))
+
+	metric = protowire.AppendTag(metric, 1, protowire.BytesType)
+	metric = protowire.AppendString(metric, "hello.world")
+	metric = protowire.AppendTag(metric, 2, protowire.BytesType)
+	metric = protowire.AppendBytes(metric, point)
+
+	payload = protowire.AppendTag(payload, 1, protowire.BytesType)
+	payload = protowire.AppendBytes(payload, metric)
+
+	message := make([]byte, 4)
+	binary.BigEndian.PutUint32(message, uint32(len(payload)))
+	message = append(message, payload...)
+
+	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcvChan := make(chan *points.Points, 128)
+	receiver := NewProtobuf(rcvChan)
+	if err = receiver.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Stop()
+
+	conn, err := net.Dial("tcp", receiver.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write(message)
+
+	select {
+	case msg := <-rcvChan:
+		if !msg.Eq(points.OnePoint("hello.world", 42, 1452200952)) {
+			t.Fatalf("%#v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message #0 not received")
+	}
+}
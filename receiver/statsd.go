@@ -0,0 +1,384 @@
+package receiver
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// statsdMetric is one parsed "name:value|type|@rate" record
+type statsdMetric struct {
+	name       string
+	value      float64
+	typ        string // "c", "g", "ms", "h" or "s"
+	sampleRate float64
+	relative   bool   // gauge with sign: "+1" or "-1"
+	set        string // raw value of set
+}
+
+// statsdName replaces chars not allowed in graphite names
+func statsdName(name string) string {
+	name = strings.Replace(name, " ", "_", -1)
+	name = strings.Replace(name, "/", "-", -1)
+	return name
+}
+
+// parseStatsd parses one line in statsd protocol
+func parseStatsd(line string) (*statsdMetric, error) {
+	colon := strings.LastIndex(line, ":")
+	if colon <= 0 {
+		return nil, fmt.Errorf("bad statsd message: %#v", line)
+	}
+
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("bad statsd message: %#v", line)
+	}
+
+	m := &statsdMetric{
+		name:       statsdName(line[:colon]),
+		typ:        parts[1],
+		sampleRate: 1,
+	}
+
+	if len(parts) == 3 {
+		if !strings.HasPrefix(parts[2], "@") {
+			return nil, fmt.Errorf("bad statsd sample rate: %#v", line)
+		}
+		rate, err := strconv.ParseFloat(parts[2][1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, fmt.Errorf("bad statsd sample rate: %#v", line)
+		}
+		m.sampleRate = rate
+	}
+
+	switch m.typ {
+	case "s":
+		m.set = parts[0]
+		return m, nil
+	case "c", "g", "ms", "h":
+	default:
+		return nil, fmt.Errorf("unknown statsd metric type: %#v", line)
+	}
+
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("bad statsd value: %#v", line)
+	}
+	m.value = value
+
+	if m.typ == "g" && (parts[0][0] == '+' || parts[0][0] == '-') {
+		m.relative = true
+	}
+
+	return m, nil
+}
+
+// statsdAggregator collects metrics between flushes
+type statsdAggregator struct {
+	sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64 // kept between flushes
+	timers   map[string][]float64
+	timerCnt map[string]float64 // count of timer values with sample rate
+	sets     map[string]map[string]bool
+}
+
+func newStatsdAggregator() *statsdAggregator {
+	a := &statsdAggregator{
+		gauges: make(map[string]float64),
+	}
+	a.reset()
+	return a
+}
+
+func (a *statsdAggregator) reset() {
+	a.counters = make(map[string]float64)
+	a.timers = make(map[string][]float64)
+	a.timerCnt = make(map[string]float64)
+	a.sets = make(map[string]map[string]bool)
+}
+
+func (a *statsdAggregator) add(m *statsdMetric) {
+	a.Lock()
+	defer a.Unlock()
+
+	switch m.typ {
+	case "c":
+		a.counters[m.name] += m.value / m.sampleRate
+	case "g":
+		if m.relative {
+			a.gauges[m.name] += m.value
+		} else {
+			a.gauges[m.name] = m.value
+		}
+	case "ms", "h":
+		a.timers[m.name] = append(a.timers[m.name], m.value)
+		a.timerCnt[m.name] += 1 / m.sampleRate
+	case "s":
+		if a.sets[m.name] == nil {
+			a.sets[m.name] = make(map[string]bool)
+		}
+		a.sets[m.name][m.set] = true
+	}
+}
+
+// percentileName returns "90" for 90 and "99_9" for 99.9
+func percentileName(pct float64) string {
+	return strings.Replace(strconv.FormatFloat(pct, 'f', -1, 64), ".", "_", -1)
+}
+
+// flush returns aggregated points and resets aggregator
+func (a *statsdAggregator) flush(prefix string, interval time.Duration, percentiles []float64, now int64) []*points.Points {
+	a.Lock()
+	defer a.Unlock()
+
+	var result []*points.Points
+
+	add := func(name string, value float64) {
+		result = append(result, points.OnePoint(prefix+name, value, now))
+	}
+
+	for name, value := range a.counters {
+		add("counters."+name+".count", value)
+		add("counters."+name+".rate", value/interval.Seconds())
+	}
+
+	for name, value := range a.gauges {
+		add("gauges."+name, value)
+	}
+
+	for name, values := range a.timers {
+		sort.Float64s(values)
+
+		count := len(values)
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+
+		base := "timers." + name + "."
+		add(base+"count", a.timerCnt[name])
+		add(base+"count_ps", a.timerCnt[name]/interval.Seconds())
+		add(base+"lower", values[0])
+		add(base+"upper", values[count-1])
+		add(base+"sum", sum)
+		add(base+"mean", sum/float64(count))
+
+		if count%2 == 1 {
+			add(base+"median", values[count/2])
+		} else {
+			add(base+"median", (values[count/2-1]+values[count/2])/2)
+		}
+
+		for _, pct := range percentiles {
+			n := int(math.Floor(float64(count)*pct/100 + 0.5))
+			if n < 1 {
+				continue
+			}
+			if n > count {
+				n = count
+			}
+
+			pctSum := 0.0
+			for _, v := range values[:n] {
+				pctSum += v
+			}
+
+			suffix := percentileName(pct)
+			add(base+"upper_"+suffix, values[n-1])
+			add(base+"sum_"+suffix, pctSum)
+			add(base+"mean_"+suffix, pctSum/float64(n))
+		}
+	}
+
+	for name, values := range a.sets {
+		add("sets."+name+".count", float64(len(values)))
+	}
+
+	a.reset()
+
+	return result
+}
+
+// StatsD receives metrics in statsd protocol from UDP socket and sends aggregated values
+type StatsD struct {
+	helper.Stoppable
+	out             chan *points.Points
+	graphPrefix     string
+	prefix          string // prefix of aggregated metrics
+	flushInterval   time.Duration
+	percentiles     []float64
+	metricsReceived uint32
+	errors          uint32
+	aggregator      *statsdAggregator
+	conn            *net.UDPConn
+	metricInterval  time.Duration
+}
+
+// NewStatsD create new instance of StatsD
+func NewStatsD(out chan *points.Points) *StatsD {
+	return &StatsD{
+		out:            out,
+		prefix:         "stats.",
+		flushInterval:  10 * time.Second,
+		percentiles:    []float64{90},
+		aggregator:     newStatsdAggregator(),
+		metricInterval: time.Minute,
+	}
+}
+
+// SetGraphPrefix for internal cache metrics
+func (rcv *StatsD) SetGraphPrefix(prefix string) {
+	rcv.graphPrefix = prefix
+}
+
+// SetPrefix sets prefix of aggregated metrics
+func (rcv *StatsD) SetPrefix(prefix string) {
+	rcv.prefix = prefix
+}
+
+// SetFlushInterval sets interval of aggregation
+func (rcv *StatsD) SetFlushInterval(interval time.Duration) {
+	rcv.flushInterval = interval
+}
+
+// SetPercentiles sets percentiles calculated for timers
+func (rcv *StatsD) SetPercentiles(percentiles []float64) {
+	rcv.percentiles = percentiles
+}
+
+// SetMetricInterval sets doChekpoint interval
+func (rcv *StatsD) SetMetricInterval(interval time.Duration) {
+	rcv.metricInterval = interval
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (rcv *StatsD) Addr() net.Addr {
+	if rcv.conn == nil {
+		return nil
+	}
+	return rcv.conn.LocalAddr()
+}
+
+// Stat sends internal statistics to cache
+func (rcv *StatsD) Stat(metric string, value float64) {
+	rcv.out <- points.OnePoint(
+		fmt.Sprintf("%s%s", rcv.graphPrefix, metric),
+		value,
+		time.Now().Unix(),
+	)
+}
+
+func (rcv *StatsD) flush() {
+	msgs := rcv.aggregator.flush(rcv.prefix, rcv.flushInterval, rcv.percentiles, time.Now().Unix())
+	for _, msg := range msgs {
+		rcv.out <- msg
+	}
+}
+
+func (rcv *StatsD) flushWorker(exit chan bool) {
+	ticker := time.NewTicker(rcv.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rcv.flush()
+		case <-exit:
+			rcv.flush()
+			return
+		}
+	}
+}
+
+func (rcv *StatsD) statWorker(exit chan bool) {
+	ticker := time.NewTicker(rcv.metricInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			metricsReceived := atomic.LoadUint32(&rcv.metricsReceived)
+			atomic.AddUint32(&rcv.metricsReceived, -metricsReceived)
+			rcv.Stat("metricsReceived", float64(metricsReceived))
+
+			errors := atomic.LoadUint32(&rcv.errors)
+			atomic.AddUint32(&rcv.errors, -errors)
+			rcv.Stat("errors", float64(errors))
+
+			logrus.WithFields(logrus.Fields{
+				"metricsReceived": int(metricsReceived),
+				"errors":          int(errors),
+			}).Info("[statsd] doCheckpoint()")
+
+		case <-exit:
+			rcv.conn.Close()
+			return
+		}
+	}
+}
+
+func (rcv *StatsD) receiveWorker(exit chan bool) {
+	defer rcv.conn.Close()
+
+	var buf [65535]byte
+
+	for {
+		rlen, _, err := rcv.conn.ReadFromUDP(buf[:])
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				break
+			}
+			atomic.AddUint32(&rcv.errors, 1)
+			logrus.Error(err)
+			continue
+		}
+
+		for _, line := range bytes.Split(buf[:rlen], []byte{'\n'}) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+
+			m, err := parseStatsd(string(line))
+			if err != nil {
+				atomic.AddUint32(&rcv.errors, 1)
+				logrus.Info(err)
+				continue
+			}
+
+			atomic.AddUint32(&rcv.metricsReceived, 1)
+			rcv.aggregator.add(m)
+		}
+	}
+}
+
+// Listen bind port. Receive messages and send aggregated values to out channel
+func (rcv *StatsD) Listen(addr *net.UDPAddr) error {
+	return rcv.StartFunc(func() error {
+		var err error
+		rcv.conn, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return err
+		}
+
+		rcv.Go(rcv.statWorker)
+		rcv.Go(rcv.flushWorker)
+		rcv.Go(rcv.receiveWorker)
+
+		return nil
+	})
+}
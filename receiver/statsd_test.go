@@ -0,0 +1,128 @@
+package receiver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatsd(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := parseStatsd("hello.world:42|c|@0.1")
+	assert.NoError(err)
+	assert.Equal(&statsdMetric{name: "hello.world", value: 42, typ: "c", sampleRate: 0.1}, m)
+
+	m, err = parseStatsd("gauge:-5|g")
+	assert.NoError(err)
+	assert.True(m.relative)
+
+	m, err = parseStatsd("users:bob|s")
+	assert.NoError(err)
+	assert.Equal("bob", m.set)
+
+	m, err = parseStatsd("my metric/name:1|ms")
+	assert.NoError(err)
+	assert.Equal("my_metric-name", m.name)
+
+	bad := []string{
+		"hello.world",
+		"hello.world:42",
+		"hello.world:42|x",
+		"hello.world:abc|c",
+		"hello.world:42|c|0.1",
+		"hello.world:42|c|@2",
+		":42|c",
+	}
+
+	for _, line := range bad {
+		_, err := parseStatsd(line)
+		assert.Error(err, line)
+	}
+}
+
+func TestStatsdAggregator(t *testing.T) {
+	assert := assert.New(t)
+
+	a := newStatsdAggregator()
+
+	lines := []string{
+		"hits:1|c",
+		"hits:2|c|@0.5",
+		"temp:10|g",
+		"temp:+5|g",
+		"users:bob|s",
+		"users:alice|s",
+		"users:bob|s",
+	}
+	for i := 1; i <= 10; i++ {
+		lines = append(lines, "req:"+string('0'+byte(i%10))+"|ms")
+	}
+
+	for _, line := range lines {
+		m, err := parseStatsd(line)
+		assert.NoError(err)
+		a.add(m)
+	}
+
+	result := make(map[string]float64)
+	for _, p := range a.flush("stats.", 10*time.Second, []float64{90}, 100) {
+		assert.Equal(int64(100), p.Data[0].Timestamp)
+		result[p.Metric] = p.Data[0].Value
+	}
+
+	assert.Equal(5.0, result["stats.counters.hits.count"])
+	assert.Equal(0.5, result["stats.counters.hits.rate"])
+	assert.Equal(15.0, result["stats.gauges.temp"])
+	assert.Equal(2.0, result["stats.sets.users.count"])
+	assert.Equal(10.0, result["stats.timers.req.count"])
+	assert.Equal(0.0, result["stats.timers.req.lower"])
+	assert.Equal(9.0, result["stats.timers.req.upper"])
+	assert.Equal(45.0, result["stats.timers.req.sum"])
+	assert.Equal(4.5, result["stats.timers.req.mean"])
+	assert.Equal(4.5, result["stats.timers.req.median"])
+	assert.Equal(8.0, result["stats.timers.req.upper_90"])
+	assert.Equal(36.0, result["stats.timers.req.sum_90"])
+
+	// gauges are sent after reset, counters are not
+	result = make(map[string]float64)
+	for _, p := range a.flush("stats.", 10*time.Second, []float64{90}, 110) {
+		result[p.Metric] = p.Data[0].Value
+	}
+	assert.Equal(map[string]float64{"stats.gauges.temp": 15}, result)
+}
+
+func TestStatsD(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcvChan := make(chan *points.Points, 128)
+	receiver := NewStatsD(rcvChan)
+	receiver.SetFlushInterval(50 * time.Millisecond)
+	if err = receiver.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Stop()
+
+	conn, err := net.Dial("udp", receiver.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("hello.world:42|g\n"))
+
+	select {
+	case msg := <-rcvChan:
+		if msg.Metric != "stats.gauges.hello.world" || msg.Data[0].Value != 42 {
+			t.Fatalf("%#v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message #0 not received")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"strings"
 	"sync/atomic"
@@ -27,7 +28,11 @@ type TCP struct {
 	active               int32 // counter
 	listener             *net.TCPListener
 	isPickle             bool
+	isProtobuf           bool
+	openTSDB             *points.OpenTSDBFormat // not nil - OpenTSDB telnet protocol
 	metricInterval       time.Duration
+	tlsConfig            *helper.TLSConfig // nil - plain TCP
+	compression          string            // "", "gzip", "snappy" or "zstd"
 }
 
 // NewTCP create new instance of TCP
@@ -49,6 +54,39 @@ func NewPickle(out chan *points.Points) *TCP {
 	}
 }
 
+// NewProtobuf create new instance of TCP with protobuf listener enabled.
+// Messages are prepended by 4-byte length as pickle
+func NewProtobuf(out chan *points.Points) *TCP {
+	return &TCP{
+		out:                  out,
+		isProtobuf:           true,
+		metricInterval:       time.Minute,
+		maxPickleMessageSize: 67108864, // 64 Mb
+	}
+}
+
+// NewOpenTSDB create new instance of TCP receiving OpenTSDB telnet "put" lines
+func NewOpenTSDB(out chan *points.Points, format *points.OpenTSDBFormat) *TCP {
+	return &TCP{
+		out:            out,
+		openTSDB:       format,
+		metricInterval: time.Minute,
+	}
+}
+
+func (rcv *TCP) name() string {
+	if rcv.isPickle {
+		return "pickle"
+	}
+	if rcv.isProtobuf {
+		return "protobuf"
+	}
+	if rcv.openTSDB != nil {
+		return "opentsdb"
+	}
+	return "tcp"
+}
+
 // SetGraphPrefix for internal cache metrics
 func (rcv *TCP) SetGraphPrefix(prefix string) {
 	rcv.graphPrefix = prefix
@@ -59,11 +97,33 @@ func (rcv *TCP) SetMetricInterval(interval time.Duration) {
 	rcv.metricInterval = interval
 }
 
-// SetMaxPickleMessageSize sets maxPickleMessageSize (in bytes)
+// SetMaxPickleMessageSize sets maxPickleMessageSize (in bytes). Used by protobuf listener too
 func (rcv *TCP) SetMaxPickleMessageSize(newSize uint32) {
 	rcv.maxPickleMessageSize = newSize
 }
 
+// SetTLS enables TLS. Call before Listen()
+func (rcv *TCP) SetTLS(config *helper.TLSConfig) {
+	rcv.tlsConfig = config
+}
+
+// SetCompression sets compression of input stream: "gzip", "snappy" (framing format), "zstd" or "none"
+func (rcv *TCP) SetCompression(compression string) error {
+	if err := checkCompression(compression); err != nil {
+		return err
+	}
+	if compression == "none" {
+		compression = ""
+	}
+	rcv.compression = compression
+	return nil
+}
+
+// ReloadTLS reads certificates from files of current config. Active connections are not affected
+func (rcv *TCP) ReloadTLS(certFile, keyFile, caFile string) error {
+	return helper.ReloadTLS(rcv.tlsConfig, certFile, keyFile, caFile)
+}
+
 // Stat sends internal statistics to cache
 func (rcv *TCP) Stat(metric string, value float64) {
 	rcv.out <- points.OnePoint(
@@ -81,12 +141,42 @@ func (rcv *TCP) Addr() net.Addr {
 	return rcv.listener.Addr()
 }
 
+// newReader returns buffered reader of decompressed connection stream
+func (rcv *TCP) newReader(conn net.Conn) (*bufio.Reader, io.Closer, error) {
+	if rcv.compression == "" {
+		return bufio.NewReader(conn), ioutil.NopCloser(nil), nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Minute)) // gzip reads header here
+
+	stream, err := decompress(rcv.compression, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bufio.NewReader(stream), stream, nil
+}
+
+// parseLine parses line of plain text or OpenTSDB protocol
+func (rcv *TCP) parseLine(line string) (*points.Points, error) {
+	if rcv.openTSDB != nil {
+		return points.ParseOpenTSDB(line, rcv.openTSDB)
+	}
+	return points.ParseText(line)
+}
+
 func (rcv *TCP) handleConnection(conn net.Conn) {
 	atomic.AddInt32(&rcv.active, 1)
 	defer atomic.AddInt32(&rcv.active, -1)
 
 	defer conn.Close()
-	reader := bufio.NewReader(conn)
+
+	reader, stream, err := rcv.newReader(conn)
+	if err != nil {
+		atomic.AddUint32(&rcv.errors, 1)
+		logrus.Warningf("[tcp] Can't read %s stream: %s", rcv.compression, err.Error())
+		return
+	}
+	defer stream.Close()
 
 	finished := make(chan bool)
 	defer close(finished)
@@ -118,7 +208,7 @@ func (rcv *TCP) handleConnection(conn net.Conn) {
 			break
 		}
 		if len(line) > 0 { // skip empty lines
-			if msg, err := points.ParseText(string(line)); err != nil {
+			if msg, err := rcv.parseLine(string(line)); err != nil {
 				atomic.AddUint32(&rcv.errors, 1)
 				logrus.Info(err)
 			} else {
@@ -129,10 +219,13 @@ func (rcv *TCP) handleConnection(conn net.Conn) {
 	}
 }
 
-func (rcv *TCP) handlePickle(conn net.Conn) {
+// handleFramed receives messages prepended by 4-byte length: pickle or protobuf
+func (rcv *TCP) handleFramed(conn net.Conn) {
+	rcvName := rcv.name()
+
 	defer func() {
 		if r := recover(); r != nil {
-			logrus.Errorf("[pickle] Unknown error recovered: %s", r)
+			logrus.Errorf("[%s] Unknown error recovered: %s", rcvName, r)
 		}
 	}()
 
@@ -140,10 +233,16 @@ func (rcv *TCP) handlePickle(conn net.Conn) {
 	defer atomic.AddInt32(&rcv.active, -1)
 
 	defer conn.Close()
-	reader := bufio.NewReader(conn)
+
+	reader, stream, err := rcv.newReader(conn)
+	if err != nil {
+		atomic.AddUint32(&rcv.errors, 1)
+		logrus.Warningf("[%s] Can't read %s stream: %s", rcvName, rcv.compression, err.Error())
+		return
+	}
+	defer stream.Close()
 
 	var msgLen uint32
-	var err error
 
 	finished := make(chan bool)
 	defer close(finished)
@@ -160,6 +259,11 @@ func (rcv *TCP) handlePickle(conn net.Conn) {
 
 	maxMessageSize := rcv.maxPickleMessageSize
 
+	parse := points.ParsePickle
+	if rcv.isProtobuf {
+		parse = points.ParseProtobuf
+	}
+
 	for {
 		conn.SetReadDeadline(time.Now().Add(2 * time.Minute))
 
@@ -171,32 +275,32 @@ func (rcv *TCP) handlePickle(conn net.Conn) {
 			}
 
 			atomic.AddUint32(&rcv.errors, 1)
-			logrus.Warningf("[pickle] Can't read message length: %s", err.Error())
+			logrus.Warningf("[%s] Can't read message length: %s", rcvName, err.Error())
 			return
 		}
 
 		if msgLen > maxMessageSize {
 			atomic.AddUint32(&rcv.errors, 1)
-			logrus.Warningf("[pickle] Bad message size: %d", msgLen)
+			logrus.Warningf("[%s] Bad message size: %d", rcvName, msgLen)
 			return
 		}
 
 		// Allocate a byte array of the expected length
 		data := make([]byte, msgLen)
 
-		// Read remainder of pickle packet into byte array
+		// Read remainder of packet into byte array
 		if err = binary.Read(reader, binary.BigEndian, data); err != nil {
 			atomic.AddUint32(&rcv.errors, 1)
-			logrus.Warningf("[pickle] Can't read message body: %s", err.Error())
+			logrus.Warningf("[%s] Can't read message body: %s", rcvName, err.Error())
 			return
 		}
 
-		msgs, err := points.ParsePickle(data)
+		msgs, err := parse(data)
 
 		if err != nil {
 			atomic.AddUint32(&rcv.errors, 1)
-			logrus.Infof("[pickle] Can't unpickle message: %s", err.Error())
-			logrus.Debugf("[pickle] Bad message: %#v", string(data))
+			logrus.Infof("[%s] Can't parse message: %s", rcvName, err.Error())
+			logrus.Debugf("[%s] Bad message: %#v", rcvName, string(data))
 			return
 		}
 
@@ -217,10 +321,7 @@ func (rcv *TCP) Listen(addr *net.TCPAddr) error {
 		}
 
 		rcv.Go(func(exit chan bool) {
-			rcvName := "tcp"
-			if rcv.isPickle {
-				rcvName = "pickle"
-			}
+			rcvName := rcv.name()
 
 			ticker := time.NewTicker(rcv.metricInterval)
 			defer ticker.Stop()
@@ -252,8 +353,8 @@ func (rcv *TCP) Listen(addr *net.TCPAddr) error {
 		})
 
 		handler := rcv.handleConnection
-		if rcv.isPickle {
-			handler = rcv.handlePickle
+		if rcv.isPickle || rcv.isProtobuf {
+			handler = rcv.handleFramed
 		}
 
 		rcv.Go(func(exit chan bool) {
@@ -270,6 +371,10 @@ func (rcv *TCP) Listen(addr *net.TCPAddr) error {
 					continue
 				}
 
+				if rcv.tlsConfig != nil {
+					conn = rcv.tlsConfig.Server(conn)
+				}
+
 				rcv.Go(func(exit chan bool) {
 					handler(conn)
 				})
@@ -1,10 +1,15 @@
 package receiver
 
 import (
+	"bytes"
+	"compress/gzip"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+
 	"github.com/lomik/go-carbon/points"
 )
 
@@ -106,3 +111,112 @@ func TestTCP2(t *testing.T) {
 		t.Fatalf("Message #1 not received")
 	}
 }
+
+func TestTCPCompression(t *testing.T) {
+	gzipStream := func(text string) []byte {
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		w.Write([]byte(text))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	snappyStream := func(text string) []byte {
+		buf := new(bytes.Buffer)
+		w := snappy.NewBufferedWriter(buf)
+		w.Write([]byte(text))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	zstdStream := func(text string) []byte {
+		buf := new(bytes.Buffer)
+		w, _ := zstd.NewWriter(buf)
+		w.Write([]byte(text))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	table := []struct {
+		compression string
+		compress    func(string) []byte
+	}{
+		{"gzip", gzipStream},
+		{"snappy", snappyStream},
+		{"zstd", zstdStream},
+	}
+
+	for _, test := range table {
+		addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rcvChan := make(chan *points.Points, 128)
+		receiver := NewTCP(rcvChan)
+		if err = receiver.SetCompression(test.compression); err != nil {
+			t.Fatal(err)
+		}
+		if err = receiver.Listen(addr); err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("tcp", receiver.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(test.compress("hello.world 42.15 1422698155\nmetric.name -72.11 1422698155\n"))
+		conn.Close()
+
+		for _, expected := range []*points.Points{
+			points.OnePoint("hello.world", 42.15, 1422698155),
+			points.OnePoint("metric.name", -72.11, 1422698155),
+		} {
+			select {
+			case msg := <-rcvChan:
+				if !msg.Eq(expected) {
+					t.Fatalf("%s: %#v != %#v", test.compression, msg, expected)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: message not received", test.compression)
+			}
+		}
+
+		receiver.Stop()
+	}
+
+	if err := NewTCP(nil).SetCompression("lzma"); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestOpenTSDB(t *testing.T) {
+	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcvChan := make(chan *points.Points, 128)
+	receiver := NewOpenTSDB(rcvChan, &points.OpenTSDBFormat{TagOrder: []string{"host"}})
+	if err = receiver.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Stop()
+
+	conn, err := net.Dial("tcp", receiver.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("put sys.cpu.user 1356998400 42.5 host=web01 cpu=0\n"))
+
+	select {
+	case msg := <-rcvChan:
+		if !msg.Eq(points.OnePoint("sys.cpu.user.web01.0", 42.5, 1356998400)) {
+			t.Fatalf("%#v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message #0 not received")
+	}
+}
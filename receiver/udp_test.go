@@ -110,6 +110,27 @@ func TestUDP2(t *testing.T) {
 	}
 }
 
+func TestUDPMetrics20(t *testing.T) {
+	test := newUDPTestCase(t)
+	defer test.Finish()
+
+	test.Send("hello.world 42.15 1422698155\nunit=B what=disk_space  agent=diamond 42 1422698155\n")
+
+	select {
+	case msg := <-test.rcvChan:
+		test.Eq(msg, points.OnePoint("hello.world", 42.15, 1422698155))
+	default:
+		t.Fatalf("Message #0 not received")
+	}
+
+	select {
+	case msg := <-test.rcvChan:
+		test.Eq(msg, points.OnePoint("disk_space;unit=B", 42, 1422698155))
+	default:
+		t.Fatalf("Message #1 not received")
+	}
+}
+
 func TestChunkedUDP(t *testing.T) {
 	test := newUDPTestCase(t)
 	defer test.Finish()
@@ -0,0 +1,228 @@
+package rewrite
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/alyu/configparser"
+
+	"github.com/lomik/go-carbon/helper"
+	"github.com/lomik/go-carbon/points"
+)
+
+// Rule replaces matched part of metric name or drops metric
+type Rule struct {
+	Name        string
+	Pattern     *regexp.Regexp
+	Replacement string // $1 or ${1} are replaced by capture groups
+	Stop        bool   // do not apply next rules after match
+	Drop        bool   // drop matched metrics
+	hits        uint64
+}
+
+// Rules is ordered list of rules
+type Rules []*Rule
+
+// ReadRules reads rewrite rules file. Sections are applied in order of file:
+//
+//	[servers]
+//	pattern = ^servers\.([^.]+)\.
+//	replacement = hosts.$1.
+//	stop = true
+//
+//	[test]
+//	pattern = ^test\.
+//	action = drop
+func ReadRules(file string) (Rules, error) {
+	config, err := configparser.Read(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := config.AllSections()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules Rules
+
+	for _, sec := range sections {
+		rule := &Rule{}
+		rule.Name =
+			strings.Trim(strings.SplitN(sec.String(), "\n", 2)[0], " []")
+		if rule.Name == "" || strings.HasPrefix(rule.Name, "#") {
+			continue
+		}
+
+		patternStr := sec.ValueOf("pattern")
+		if patternStr == "" {
+			return nil, fmt.Errorf("[rewrite] Empty pattern for [%s]", rule.Name)
+		}
+		rule.Pattern, err = regexp.Compile(patternStr)
+		if err != nil {
+			return nil, fmt.Errorf("[rewrite] Failed to parse pattern %q for [%s]: %s",
+				patternStr, rule.Name, err.Error())
+		}
+
+		rule.Replacement = sec.ValueOf("replacement")
+
+		if stopStr := sec.ValueOf("stop"); stopStr != "" {
+			rule.Stop, err = strconv.ParseBool(stopStr)
+			if err != nil {
+				return nil, fmt.Errorf("[rewrite] Failed to parse stop %q for [%s]: %s",
+					stopStr, rule.Name, err.Error())
+			}
+		}
+
+		switch action := sec.ValueOf("action"); action {
+		case "", "rewrite":
+		case "drop":
+			rule.Drop = true
+		default:
+			return nil, fmt.Errorf("[rewrite] Unknown action %q for [%s]", action, rule.Name)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Apply returns new metric name. Empty name means that metric should be dropped
+func (rules Rules) Apply(metric string) string {
+	for _, rule := range rules {
+		if !rule.Pattern.MatchString(metric) {
+			continue
+		}
+
+		atomic.AddUint64(&rule.hits, 1)
+
+		if rule.Drop {
+			return ""
+		}
+
+		metric = rule.Pattern.ReplaceAllString(metric, rule.Replacement)
+
+		if rule.Stop || metric == "" {
+			break
+		}
+	}
+	return metric
+}
+
+// Rewriter applies rules to metrics from In() channel and sends them to out channel
+type Rewriter struct {
+	helper.Stoppable
+	rulesMutex     sync.RWMutex
+	in             chan *points.Points
+	out            chan *points.Points
+	rules          Rules
+	graphPrefix    string
+	metricInterval time.Duration
+	dropped        uint32
+}
+
+// New create new instance of Rewriter
+func New(out chan *points.Points) *Rewriter {
+	return &Rewriter{
+		in:             make(chan *points.Points, 1024),
+		out:            out,
+		metricInterval: time.Minute,
+	}
+}
+
+// In returns input channel
+func (r *Rewriter) In() chan *points.Points {
+	return r.in
+}
+
+// SetRules replaces rules. Can be called after Start()
+func (r *Rewriter) SetRules(rules Rules) {
+	r.rulesMutex.Lock()
+	r.rules = rules
+	r.rulesMutex.Unlock()
+}
+
+// SetGraphPrefix for internal rewriter metrics
+func (r *Rewriter) SetGraphPrefix(prefix string) {
+	r.graphPrefix = prefix
+}
+
+// SetMetricInterval sets doChekpoint interval
+func (r *Rewriter) SetMetricInterval(interval time.Duration) {
+	r.metricInterval = interval
+}
+
+// Stat sends internal statistics to cache
+func (r *Rewriter) Stat(metric string, value float64) {
+	r.out <- points.OnePoint(
+		fmt.Sprintf("%s%s", r.graphPrefix, metric),
+		value,
+		time.Now().Unix(),
+	)
+}
+
+func (r *Rewriter) doCheckpoint() {
+	r.rulesMutex.RLock()
+	rules := r.rules
+	r.rulesMutex.RUnlock()
+
+	for _, rule := range rules {
+		hits := atomic.LoadUint64(&rule.hits)
+		atomic.AddUint64(&rule.hits, -hits)
+		r.Stat(fmt.Sprintf("hits.%s", strings.Replace(rule.Name, ".", "_", -1)), float64(hits))
+	}
+
+	dropped := atomic.LoadUint32(&r.dropped)
+	atomic.AddUint32(&r.dropped, -dropped)
+	r.Stat("dropped", float64(dropped))
+
+	logrus.WithFields(logrus.Fields{
+		"rules":   len(rules),
+		"dropped": int(dropped),
+	}).Info("[rewrite] doCheckpoint()")
+}
+
+func (r *Rewriter) rewrite(p *points.Points) {
+	r.rulesMutex.RLock()
+	rules := r.rules
+	r.rulesMutex.RUnlock()
+
+	p.Metric = rules.Apply(p.Metric)
+	if p.Metric == "" {
+		atomic.AddUint32(&r.dropped, 1)
+		return
+	}
+
+	r.out <- p
+}
+
+// Start starts workers
+func (r *Rewriter) Start() error {
+	return r.StartFunc(func() error {
+		helper.NewShuffler(r.in, runtime.GOMAXPROCS(0), r.rewrite).Start(&r.Stoppable)
+
+		r.Go(func(exit chan bool) {
+			ticker := time.NewTicker(r.metricInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					r.doCheckpoint()
+				case <-exit:
+					return
+				}
+			}
+		})
+
+		return nil
+	})
+}
@@ -0,0 +1,132 @@
+package rewrite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lomik/go-carbon/points"
+	"github.com/lomik/go-carbon/qa"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseRules(t *testing.T, content string) (rules Rules, err error) {
+	qa.File(t, "rewrite.conf", content, func(filename string) {
+		rules, err = ReadRules(filename)
+	})
+	return
+}
+
+func TestReadRules(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, err := parseRules(t, `
+[servers]
+pattern = ^servers\.([^.]+)\.
+replacement = hosts.$1.
+stop = true
+
+# comment
+[test]
+pattern = ^test\.
+action = drop
+
+[strip]
+pattern = \.value$
+`)
+	assert.NoError(err)
+	if assert.Len(rules, 3) {
+		assert.Equal("servers", rules[0].Name)
+		assert.Equal("hosts.$1.", rules[0].Replacement)
+		assert.True(rules[0].Stop)
+		assert.False(rules[0].Drop)
+		assert.True(rules[1].Drop)
+		assert.Equal("", rules[2].Replacement)
+	}
+
+	bad := []string{
+		"[a]\nreplacement = b\n",
+		"[a]\npattern = (\n",
+		"[a]\npattern = a\nstop = maybe\n",
+		"[a]\npattern = a\naction = delete\n",
+	}
+
+	for _, content := range bad {
+		_, err := parseRules(t, content)
+		assert.Error(err, content)
+	}
+}
+
+func TestApply(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, err := parseRules(t, `
+[servers]
+pattern = ^servers\.([^.]+)\.
+replacement = hosts.$1.
+stop = true
+
+[test]
+pattern = ^test\.
+action = drop
+
+[strip]
+pattern = \.value$
+
+[cpu]
+pattern = ^hosts\.
+replacement = stopped.
+`)
+	assert.NoError(err)
+
+	assert.Equal("hosts.web01.cpu.value", rules.Apply("servers.web01.cpu.value"))
+	assert.Equal("", rules.Apply("test.metric"))
+	assert.Equal("stopped.web01.cpu", rules.Apply("hosts.web01.cpu.value"))
+	assert.Equal("other.metric", rules.Apply("other.metric"))
+
+	assert.Equal(uint64(1), rules[0].hits)
+	assert.Equal(uint64(1), rules[1].hits)
+	assert.Equal(uint64(1), rules[2].hits)
+	assert.Equal(uint64(1), rules[3].hits)
+}
+
+func TestRewriter(t *testing.T) {
+	assert := assert.New(t)
+
+	rules, err := parseRules(t, "[a]\npattern = ^a\\.\nreplacement = b.\n\n[drop]\npattern = ^c\\.\naction = drop\n")
+	assert.NoError(err)
+
+	out := make(chan *points.Points, 128)
+	r := New(out)
+	r.SetRules(rules)
+	r.SetGraphPrefix("carbon.agents.localhost.rewrite.")
+	r.SetMetricInterval(50 * time.Millisecond)
+	r.Start()
+	defer r.Stop()
+
+	r.In() <- points.OnePoint("c.metric", 1, 1422698155)
+	r.In() <- points.OnePoint("a.metric", 1, 1422698155)
+
+	select {
+	case p := <-out:
+		assert.True(p.Eq(points.OnePoint("b.metric", 1, 1422698155)))
+	case <-time.After(time.Second):
+		t.Fatal("metric not received")
+	}
+
+	stats := make(map[string]float64)
+	timeout := time.After(time.Second)
+	for stats["carbon.agents.localhost.rewrite.dropped"] < 1 {
+		select {
+		case p := <-out:
+			stats[p.Metric] += p.Data[0].Value
+		case <-timeout:
+			t.Fatalf("stats not received: %#v", stats)
+		}
+	}
+
+	assert.Equal(map[string]float64{
+		"carbon.agents.localhost.rewrite.hits.a":    1,
+		"carbon.agents.localhost.rewrite.hits.drop": 1,
+		"carbon.agents.localhost.rewrite.dropped":   1,
+	}, stats)
+}
@@ -0,0 +1,151 @@
+// Package tags implements graphite 1.1 tagged series support.
+// See https://graphite.readthedocs.io/en/latest/tags.html
+package tags
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// IsTagged returns true for metric names like "cpu.load;host=a"
+func IsTagged(metric string) bool {
+	return strings.IndexByte(metric, ';') >= 0
+}
+
+type tagSorter []string
+
+func (v tagSorter) Len() int      { return len(v) }
+func (v tagSorter) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v tagSorter) Less(i, j int) bool {
+	// compare by tag name only. "a=1" < "a.b=1"
+	return tagName(v[i]) < tagName(v[j])
+}
+
+func tagName(tag string) string {
+	if p := strings.IndexByte(tag, '='); p >= 0 {
+		return tag[:p]
+	}
+	return tag
+}
+
+// Normalize validates tagged series and sorts its tags by name.
+// Metric names without tags are returned as is
+func Normalize(metric string) (string, error) {
+	if !IsTagged(metric) {
+		return metric, nil
+	}
+
+	parts := strings.Split(metric, ";")
+	if parts[0] == "" {
+		return "", fmt.Errorf("empty name of tagged series %#v", metric)
+	}
+
+	list := parts[1:]
+	seen := make(map[string]bool, len(list))
+
+	for _, tag := range list {
+		p := strings.IndexByte(tag, '=')
+		if p <= 0 || p == len(tag)-1 {
+			return "", fmt.Errorf("bad tag %#v in %#v", tag, metric)
+		}
+		name, value := tag[:p], tag[p+1:]
+		if strings.ContainsAny(name, "!^=") {
+			return "", fmt.Errorf("bad tag name %#v in %#v", name, metric)
+		}
+		if value[0] == '~' {
+			return "", fmt.Errorf("bad tag value %#v in %#v", value, metric)
+		}
+		if seen[name] {
+			return "", fmt.Errorf("duplicate tag %#v in %#v", name, metric)
+		}
+		seen[name] = true
+	}
+
+	sort.Sort(tagSorter(list))
+
+	return parts[0] + ";" + strings.Join(list, ";"), nil
+}
+
+// Tags returns name and tag values of tagged series. Name tag is also added as graphite does
+func Tags(metric string) map[string]string {
+	parts := strings.Split(metric, ";")
+
+	result := make(map[string]string, len(parts))
+	result["name"] = parts[0]
+
+	for _, tag := range parts[1:] {
+		if p := strings.IndexByte(tag, '='); p > 0 {
+			result[tag[:p]] = tag[p+1:]
+		}
+	}
+
+	return result
+}
+
+// taggedFileName escapes tagged series for file name. "/" in tag values never creates directories
+var taggedFileName = strings.NewReplacer(".", "_DOT_", "/", "_SLASH_")
+
+// FilePath returns path of whisper file for metric.
+// Tagged series are stored as graphite does: _tagged/<hash[0:3]>/<hash[3:6]>/<name with "." replaced to "_DOT_">.
+// "/" is replaced to "_SLASH_"
+func FilePath(root string, metric string) string {
+	if !IsTagged(metric) {
+		return filepath.Join(root, strings.Replace(metric, ".", "/", -1)+".wsp")
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(metric)))
+
+	return filepath.Join(
+		root,
+		"_tagged",
+		hash[:3],
+		hash[3:6],
+		taggedFileName.Replace(metric)+".wsp",
+	)
+}
+
+// Matcher checks tag values of tagged series with regular expressions
+type Matcher map[string]*regexp.Regexp
+
+// ParseMatcher parses space separated list of "tag=regexp" expressions
+func ParseMatcher(expr string) (Matcher, error) {
+	m := make(Matcher)
+
+	for _, item := range strings.Fields(expr) {
+		p := strings.IndexByte(item, '=')
+		if p <= 0 {
+			return nil, fmt.Errorf("bad tag expression %#v", item)
+		}
+
+		re, err := regexp.Compile(item[p+1:])
+		if err != nil {
+			return nil, fmt.Errorf("bad tag expression %#v: %s", item, err.Error())
+		}
+
+		m[item[:p]] = re
+	}
+
+	return m, nil
+}
+
+// Match returns true if all tags of metric exist and match expressions
+func (m Matcher) Match(metric string) bool {
+	if len(m) == 0 {
+		return true
+	}
+
+	values := Tags(metric)
+
+	for name, re := range m {
+		value, exists := values[name]
+		if !exists || !re.MatchString(value) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,90 @@
+package tags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	assert := assert.New(t)
+
+	table := []struct {
+		in  string
+		out string
+	}{
+		{"cpu.load", "cpu.load"},
+		{"cpu.load;host=a;dc=x", "cpu.load;dc=x;host=a"},
+		{"cpu.load;dc=x;host=a", "cpu.load;dc=x;host=a"},
+		{"cpu.load;b=1;a.b=2;a=3", "cpu.load;a=3;a.b=2;b=1"},
+	}
+
+	for _, test := range table {
+		out, err := Normalize(test.in)
+		assert.NoError(err)
+		assert.Equal(test.out, out)
+	}
+
+	bad := []string{
+		";host=a",
+		"cpu.load;host",
+		"cpu.load;host=",
+		"cpu.load;=a",
+		"cpu.load;host=a;host=b",
+		"cpu.load;host=~a",
+		"cpu.load;ho!st=a",
+		"cpu.load;",
+	}
+
+	for _, metric := range bad {
+		_, err := Normalize(metric)
+		assert.Error(err, metric)
+	}
+}
+
+func TestTags(t *testing.T) {
+	assert.Equal(t,
+		map[string]string{"name": "cpu.load", "dc": "x", "host": "a"},
+		Tags("cpu.load;dc=x;host=a"),
+	)
+}
+
+func TestFilePath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("/data/cpu/load.wsp", FilePath("/data", "cpu.load"))
+	assert.Equal(
+		"/data/_tagged/9bf/fe0/cpu_DOT_load;dc=x;host=a.wsp",
+		FilePath("/data", "cpu.load;dc=x;host=a"),
+	)
+	assert.Equal(
+		"/data/_tagged/2cb/393/disk_DOT_free;path=_SLASH_var_SLASH__DOT__DOT__SLASH_lib.wsp",
+		FilePath("/data", "disk.free;path=/var/../lib"),
+	)
+}
+
+func TestMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := ParseMatcher("host=^web\\d+$ dc=ams")
+	assert.NoError(err)
+
+	assert.True(m.Match("cpu.load;dc=ams1;host=web12"))
+	assert.False(m.Match("cpu.load;dc=ams1;host=db12"))
+	assert.False(m.Match("cpu.load;host=web12"))
+	assert.False(m.Match("cpu.load"))
+
+	m, err = ParseMatcher("name=^cpu\\.")
+	assert.NoError(err)
+	assert.True(m.Match("cpu.load;host=a"))
+
+	m, err = ParseMatcher("")
+	assert.NoError(err)
+	assert.True(m.Match("cpu.load"))
+
+	_, err = ParseMatcher("host")
+	assert.Error(err)
+
+	_, err = ParseMatcher("host=[")
+	assert.Error(err)
+}